@@ -0,0 +1,257 @@
+package wxpayslim
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+)
+
+const (
+	transferToBankUrl      = prefix + "/mmpaysptrans/pay_bank"
+	transferToBankQueryUrl = prefix + "/mmpaysptrans/query_bank"
+
+	getPublicKeyUrl = "https://fraud.mch.weixin.qq.com/risk/getpublickey"
+)
+
+// Bank codes used in TransferToBankRequest. Full list:
+// https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay_yhk.php?chapter=24_4
+const (
+	BankCodeICBC  = "1002" // 工商银行
+	BankCodeABC   = "1005" // 农业银行
+	BankCodeBOC   = "1026" // 中国银行
+	BankCodeCCB   = "1003" // 建设银行
+	BankCodeCMB   = "1001" // 招商银行
+	BankCodePSBC  = "1066" // 邮储银行
+	BankCodeBCOM  = "1020" // 交通银行
+	BankCodeSPDB  = "1004" // 浦发银行
+	BankCodeCMBC  = "1006" // 民生银行
+	BankCodeCIB   = "1009" // 兴业银行
+	BankCodePAB   = "1010" // 平安银行
+	BankCodeCITIC = "1021" // 中信银行
+	BankCodeHXB   = "1025" // 华夏银行
+	BankCodeCGB   = "1027" // 广发银行
+	BankCodeCEB   = "1022" // 光大银行
+	BankCodeBOB   = "4836" // 北京银行
+	BankCodeNBCB  = "1056" // 宁波银行
+)
+
+// GetBankPublicKey downloads RSA public key (PKCS#1) used to encrypt bank
+// account and name in TransferToBank. It should be saved and set with
+// SetBankPublicKey. Need to set certificate (client.SetCertificate) first.
+// Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay_yhk.php?chapter=24_7&index=4
+func (client *Client) GetBankPublicKey(ctx context.Context) (*GetBankPublicKeyResponse, error) {
+	var res GetBankPublicKeyResponse
+	if err := client.postXml(ctx, getPublicKeyUrl, getBankPublicKeyRequest{}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+type getBankPublicKeyRequest struct{}
+
+var _ requestable = (*getBankPublicKeyRequest)(nil)
+var _ unsignedResponder = (*getBankPublicKeyRequest)(nil)
+
+func (r getBankPublicKeyRequest) toXml(client *Client) requestXml {
+	req := getBankPublicKeyRequestXml{}
+	req.MchId = client.MchId
+	req.NonceStr = randomStr(32)
+	req.SignType = "MD5"
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+func (r getBankPublicKeyRequest) unsignedResponse() {}
+
+type getBankPublicKeyRequestXml struct {
+	XMLName  xml.Name `xml:"xml"`
+	MchId    string   `xml:"mch_id"`
+	NonceStr string   `xml:"nonce_str"`
+	Sign     string   `xml:"sign"`
+	SignType string   `xml:"sign_type"`
+}
+
+type GetBankPublicKeyResponse struct {
+	Response
+	MchId  string `xml:"mch_id"`
+	PubKey string `xml:"pub_key"` // starts with -----BEGIN RSA PUBLIC KEY-----
+}
+
+var _ responsible = (*GetBankPublicKeyResponse)(nil)
+
+func (r GetBankPublicKeyResponse) AsError() error {
+	return ResponseError(r.Response)
+}
+
+// SetBankPublicKey sets RSA public key (PKCS#1, string starts with -----BEGIN
+// RSA PUBLIC KEY-----) from GetBankPublicKey.
+func (client *Client) SetBankPublicKey(publicKeyPEM string) error {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return errors.New("invalid public key")
+	}
+	key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	client.bankPublicKey = key
+	return nil
+}
+
+func (client *Client) encryptWithBankPublicKey(plaintext string) (string, error) {
+	if client.bankPublicKey == nil {
+		return "", errors.New("bank public key is not set, use GetBankPublicKey and SetBankPublicKey first")
+	}
+	ciphertext, err := rsa.EncryptOAEP(sha1.New(), cryptoRand.Reader, client.bankPublicKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Transfer money to bank card. BankNo and TrueName are encrypted with the
+// public key set by SetBankPublicKey. Need to set certificate
+// (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay_yhk.php?chapter=24_2
+func (client *Client) TransferToBank(ctx context.Context, req TransferToBankRequest) (*TransferToBankResponse, error) {
+	encBankNo, err := client.encryptWithBankPublicKey(req.BankNo)
+	if err != nil {
+		return nil, err
+	}
+	encTrueName, err := client.encryptWithBankPublicKey(req.TrueName)
+	if err != nil {
+		return nil, err
+	}
+	var res TransferToBankResponse
+	encrypted := encryptedTransferToBankRequest{req, encBankNo, encTrueName}
+	if err := client.postXml(ctx, transferToBankUrl, encrypted, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// TransferToBankRequest is used in TransferToBank() function.
+type TransferToBankRequest struct {
+	PartnerTradeNo string // required
+	BankNo         string // required, bank card number
+	TrueName       string // required, name of the card holder
+	BankCode       string // required, see BankCode* constants
+	Amount         int    // required, in cents
+	Desc           string // optional, max length is 100
+}
+
+type encryptedTransferToBankRequest struct {
+	TransferToBankRequest
+	encBankNo   string
+	encTrueName string
+}
+
+var _ requestable = (*encryptedTransferToBankRequest)(nil)
+
+func (r encryptedTransferToBankRequest) toXml(client *Client) requestXml {
+	req := transferToBankRequestXml{}
+	req.MchId = client.MchId
+	req.PartnerTradeNo = r.PartnerTradeNo
+	req.NonceStr = randomStr(32)
+	req.EncBankNo = r.encBankNo
+	req.EncTrueName = r.encTrueName
+	req.BankCode = r.BankCode
+	req.Amount = r.Amount
+	req.Desc = r.Desc
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type transferToBankRequestXml struct {
+	XMLName        xml.Name `xml:"xml"`
+	MchId          string   `xml:"mch_id"`
+	PartnerTradeNo string   `xml:"partner_trade_no"`
+	NonceStr       string   `xml:"nonce_str"`
+	Sign           string   `xml:"sign"`
+	EncBankNo      string   `xml:"enc_bank_no"`
+	EncTrueName    string   `xml:"enc_true_name"`
+	BankCode       string   `xml:"bank_code"`
+	Amount         int      `xml:"amount"`
+	Desc           string   `xml:"desc,omitempty"`
+}
+
+type TransferToBankResponse struct {
+	Response
+	MchId          string `xml:"mch_id"`
+	PartnerTradeNo string `xml:"partner_trade_no"`
+	Amount         int    `xml:"amount"`
+	PaymentNo      string `xml:"payment_no"`
+	CmmsAmt        int    `xml:"cmms_amt"` // service charge in cents
+}
+
+var _ responsible = (*TransferToBankResponse)(nil)
+
+func (r TransferToBankResponse) AsError() error {
+	return ResponseError(r.Response)
+}
+
+// Query transfer to bank card. Need to set certificate
+// (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay_yhk.php?chapter=24_3
+func (client *Client) TransferToBankQuery(ctx context.Context, req TransferToBankQueryRequest) (*TransferToBankQueryResponse, error) {
+	var res TransferToBankQueryResponse
+	if err := client.postXml(ctx, transferToBankQueryUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// TransferToBankQueryRequest is used in TransferToBankQuery() function.
+type TransferToBankQueryRequest struct {
+	PartnerTradeNo string // required
+}
+
+var _ requestable = (*TransferToBankQueryRequest)(nil)
+var _ unsignedResponder = (*TransferToBankQueryRequest)(nil)
+
+func (r TransferToBankQueryRequest) toXml(client *Client) requestXml {
+	req := transferToBankQueryRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.NonceStr = randomStr(32)
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+func (r TransferToBankQueryRequest) unsignedResponse() {}
+
+type transferToBankQueryRequestXml struct {
+	XMLName        xml.Name `xml:"xml"`
+	MchId          string   `xml:"mch_id"`
+	PartnerTradeNo string   `xml:"partner_trade_no"`
+	NonceStr       string   `xml:"nonce_str"`
+	Sign           string   `xml:"sign"`
+}
+
+type TransferToBankQueryResponse struct {
+	Response
+	MchId          string    `xml:"mch_id"`
+	PartnerTradeNo string    `xml:"partner_trade_no"`
+	PaymentNo      string    `xml:"payment_no"`
+	BankNoMd5      string    `xml:"bank_no_md5"`
+	TrueNameMd5    string    `xml:"true_name_md5"`
+	Amount         int       `xml:"amount"`
+	Status         string    `xml:"status"` // PROCESSING, SUCCESS, FAILED or BANK_FAIL
+	CmmsAmt        int       `xml:"cmms_amt"`
+	CreateTime     *Utc8Time `xml:"create_time"`
+	PaySuccTime    *Utc8Time `xml:"pay_succ_time"`
+	Reason         string    `xml:"reason"`
+}
+
+var _ responsible = (*TransferToBankQueryResponse)(nil)
+
+func (r TransferToBankQueryResponse) AsError() error {
+	return ResponseError(r.Response)
+}
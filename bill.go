@@ -0,0 +1,288 @@
+package wxpayslim
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+const (
+	downloadBillUrl     = prefix + "/pay/downloadbill"
+	downloadFundFlowUrl = prefix + "/pay/downloadfundflow"
+)
+
+// DownloadBill downloads and parses trade bill of a day. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_6
+func (client *Client) DownloadBill(ctx context.Context, req DownloadBillRequest) (*Bill, error) {
+	b, err := client.download(ctx, downloadBillUrl, req)
+	if err != nil {
+		return nil, err
+	}
+	var bill Bill
+	if err := parseBill(b, &bill.Records, &bill.Summary); err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+type DownloadBillRequest struct {
+	AppId    string // required
+	SignType string // optional, either MD5 (default) or HMAC-SHA256
+	BillDate string // required, format: 20060102
+	BillType string // optional, can be ALL (default), SUCCESS, REFUND or RECHARGE_REFUND
+	TarType  string // optional, set to GZIP to download compressed bill
+}
+
+var _ requestable = (*DownloadBillRequest)(nil)
+
+func (r DownloadBillRequest) toXml(client *Client) requestXml {
+	req := downloadBillRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	if req.BillType == "" {
+		req.BillType = "ALL"
+	}
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type downloadBillRequestXml struct {
+	XMLName  xml.Name `xml:"xml"`
+	AppId    string   `xml:"appid"`
+	MchId    string   `xml:"mch_id"`
+	SubMchId string   `xml:"sub_mch_id,omitempty"`
+	NonceStr string   `xml:"nonce_str"`
+	Sign     string   `xml:"sign"`
+	SignType string   `xml:"sign_type,omitempty"`
+	BillDate string   `xml:"bill_date"`
+	BillType string   `xml:"bill_type"`
+	TarType  string   `xml:"tar_type,omitempty"`
+}
+
+// Bill is the parsed result of DownloadBill. Amounts are in yuan, as is.
+type Bill struct {
+	Records []BillRecord
+	Summary BillSummary
+}
+
+// BillRecord is a row of the trade bill. Columns depend on the bill type;
+// fields of missing columns are left empty.
+type BillRecord struct {
+	TradeTime           string `bill:"交易时间"`
+	AppId               string `bill:"公众账号ID"`
+	MchId               string `bill:"商户号"`
+	SubMchId            string `bill:"特约商户号,子商户号"`
+	DeviceInfo          string `bill:"设备号"`
+	TransactionId       string `bill:"微信订单号"`
+	OutTradeNo          string `bill:"商户订单号"`
+	OpenId              string `bill:"用户标识"`
+	TradeType           string `bill:"交易类型"`
+	TradeState          string `bill:"交易状态"`
+	BankType            string `bill:"付款银行"`
+	FeeType             string `bill:"货币种类"`
+	SettlementTotalFee  string `bill:"应结订单金额"`
+	CouponFee           string `bill:"代金券金额,代金券或立减优惠金额"`
+	RefundApplyTime     string `bill:"退款申请时间"`
+	RefundSuccessTime   string `bill:"退款成功时间"`
+	RefundId            string `bill:"微信退款单号"`
+	OutRefundNo         string `bill:"商户退款单号"`
+	SettlementRefundFee string `bill:"退款金额"`
+	CouponRefundFee     string `bill:"充值券退款金额,代金券或立减优惠退款金额,企业红包退款金额"`
+	RefundType          string `bill:"退款类型"`
+	RefundStatus        string `bill:"退款状态"`
+	Body                string `bill:"商品名称"`
+	Attach              string `bill:"商户数据包"`
+	ServiceCharge       string `bill:"手续费"`
+	Rate                string `bill:"费率"`
+	TotalFee            string `bill:"订单金额"`
+	RefundFee           string `bill:"申请退款金额"`
+	RateNotes           string `bill:"费率备注"`
+}
+
+type BillSummary struct {
+	TotalCount         string `bill:"总交易单数"`
+	SettlementTotalFee string `bill:"应结订单总金额"`
+	RefundFee          string `bill:"退款总金额,总退款金额"`
+	CouponRefundFee    string `bill:"充值券退款总金额,总代金券或立减优惠退款金额,企业红包退款总金额"`
+	ServiceCharge      string `bill:"手续费总金额"`
+	TotalFee           string `bill:"订单总金额"`
+	ApplyRefundFee     string `bill:"申请退款总金额"`
+}
+
+// DownloadFundFlow downloads and parses fund flow bill of a day. Need to set
+// certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_18&index=7
+func (client *Client) DownloadFundFlow(ctx context.Context, req DownloadFundFlowRequest) (*FundFlowBill, error) {
+	b, err := client.download(ctx, downloadFundFlowUrl, req)
+	if err != nil {
+		return nil, err
+	}
+	var bill FundFlowBill
+	if err := parseBill(b, &bill.Records, &bill.Summary); err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+type DownloadFundFlowRequest struct {
+	AppId       string // required
+	BillDate    string // required, format: 20060102
+	AccountType string // required, can be Basic, Operation or Fees
+	TarType     string // optional, set to GZIP to download compressed bill
+}
+
+var _ requestable = (*DownloadFundFlowRequest)(nil)
+
+func (r DownloadFundFlowRequest) toXml(client *Client) requestXml {
+	req := downloadFundFlowRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.NonceStr = randomStr(32)
+	req.SignType = "HMAC-SHA256" // only HMAC-SHA256 is supported
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type downloadFundFlowRequestXml struct {
+	XMLName     xml.Name `xml:"xml"`
+	AppId       string   `xml:"appid"`
+	MchId       string   `xml:"mch_id"`
+	NonceStr    string   `xml:"nonce_str"`
+	Sign        string   `xml:"sign"`
+	SignType    string   `xml:"sign_type"`
+	BillDate    string   `xml:"bill_date"`
+	AccountType string   `xml:"account_type"`
+	TarType     string   `xml:"tar_type,omitempty"`
+}
+
+// FundFlowBill is the parsed result of DownloadFundFlow. Amounts are in yuan,
+// as is.
+type FundFlowBill struct {
+	Records []FundFlowRecord
+	Summary FundFlowSummary
+}
+
+type FundFlowRecord struct {
+	BillingTime      string `bill:"记账时间"`
+	BizTransactionId string `bill:"微信支付业务单号"`
+	FundFlowId       string `bill:"资金流水单号"`
+	BizName          string `bill:"业务名称"`
+	BizType          string `bill:"业务类型"`
+	FinancialType    string `bill:"收支类型"` // 收入 or 支出
+	FinancialFee     string `bill:"收支金额（元）"`
+	Balance          string `bill:"账户结余（元）"`
+	Applicant        string `bill:"资金变更提交申请人"`
+	Memo             string `bill:"备注"`
+	BizVoucherId     string `bill:"业务凭证号"`
+}
+
+type FundFlowSummary struct {
+	TotalCount        string `bill:"资金流水总笔数"`
+	IncomeCount       string `bill:"收入笔数"`
+	IncomeAmount      string `bill:"收入金额"`
+	ExpenditureCount  string `bill:"支出笔数"`
+	ExpenditureAmount string `bill:"支出金额"`
+}
+
+// download posts request and returns the bill text, decompressed if it is
+// gzipped. If WeChat returns XML, it is returned as ResponseError.
+func (client *Client) download(ctx context.Context, url string, object requestable) ([]byte, error) {
+	b, err := client.postXmlData(ctx, url, object.toXml(client))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("<xml>")) {
+		var res Response
+		if err := xml.Unmarshal(b, &res); err != nil {
+			return nil, err
+		}
+		return nil, ResponseError(res)
+	}
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return b, nil
+}
+
+// parseBill parses bill text, which consists of a header line, record lines,
+// a summary header line and a summary line. Values of the record and summary
+// lines are prefixed with backtick, which is removed. Records are appended
+// to records (pointer to slice of struct) and summary is set to summary
+// (pointer to struct), by matching column names with the bill tag.
+func parseBill(data []byte, records, summary interface{}) error {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var lines [][]string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var fields []string
+		if strings.HasPrefix(line, "`") {
+			// values may contain comma, like body or attach
+			fields = strings.Split(line[1:], ",`")
+		} else {
+			fields = strings.Split(line, ",")
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		lines = append(lines, fields)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	header := lines[0]
+	i := 1
+	rv := reflect.ValueOf(records).Elem()
+	for ; i < len(lines); i++ {
+		// summary header is the only line not prefixed with backtick
+		if isBillHeader(lines[i], reflect.TypeOf(summary).Elem()) {
+			break
+		}
+		record := reflect.New(rv.Type().Elem()).Elem()
+		setBillFields(record, header, lines[i])
+		rv.Set(reflect.Append(rv, record))
+	}
+	if i+1 < len(lines) {
+		setBillFields(reflect.ValueOf(summary).Elem(), lines[i], lines[i+1])
+	}
+	return nil
+}
+
+func isBillHeader(fields []string, rt reflect.Type) bool {
+	return len(fields) > 0 && billFieldIndex(rt, fields[0]) >= 0
+}
+
+func billFieldIndex(rt reflect.Type, column string) int {
+	for i := 0; i < rt.NumField(); i++ {
+		for _, name := range strings.Split(rt.Field(i).Tag.Get("bill"), ",") {
+			if name == column {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func setBillFields(rv reflect.Value, header, fields []string) {
+	for i, column := range header {
+		if i >= len(fields) {
+			break
+		}
+		if index := billFieldIndex(rv.Type(), column); index >= 0 {
+			rv.Field(index).SetString(fields[i])
+		}
+	}
+}
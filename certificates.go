@@ -0,0 +1,309 @@
+package wxpayslim
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const v3CertificatesUrl = prefix + "/v3/certificates"
+
+// DefaultCertificatesUpdateInterval is used by AutoUpdateCertificates if
+// interval is zero.
+const DefaultCertificatesUpdateInterval = 12 * time.Hour
+
+// UpdateCertificates downloads WeChat Pay platform certificates, decrypts them
+// with client's APIv3Key and keeps them in client, replacing the old ones.
+// Need to set certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/platform-certificate/api-v3-get-certificates/get.html
+func (client *Client) UpdateCertificates(ctx context.Context) error {
+	b, resp, err := client.sendJson(ctx, http.MethodGet, v3CertificatesUrl, nil, "")
+	if err != nil {
+		return err
+	}
+	var res V3CertificatesResponse
+	if err := decodeJsonResponse(b, resp.StatusCode, &res); err != nil {
+		return err
+	}
+	certificates := map[string]*x509.Certificate{}
+	for _, data := range res.Data {
+		plaintext, err := client.decryptResource(data.EncryptCertificate)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(plaintext)
+		if block == nil {
+			return errors.New("invalid platform certificate " + data.SerialNo)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		certificates[data.SerialNo] = cert
+	}
+	// the response can only be verified with the certificates just decrypted
+	serial := resp.Header.Get("Wechatpay-Serial")
+	cert := certificates[serial]
+	if cert == nil {
+		return SignatureVerificationError{serial, "platform certificate not found"}
+	}
+	if err := verifySignature(cert.PublicKey, serial, resp.Header, b); err != nil {
+		return err
+	}
+	client.certificates().set(certificates)
+	return nil
+}
+
+// AutoUpdateCertificates calls UpdateCertificates immediately and then every
+// interval (DefaultCertificatesUpdateInterval if zero) in background until
+// ctx is done. Error of the first update is returned, errors of later updates
+// are logged and the old certificates are kept.
+func (client *Client) AutoUpdateCertificates(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultCertificatesUpdateInterval
+	}
+	if err := client.UpdateCertificates(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := client.UpdateCertificates(ctx); err != nil {
+					log.Println("failed to update platform certificates:", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// PlatformCertificate returns the platform certificate with serial number, or
+// nil if not found or expired.
+func (client *Client) PlatformCertificate(serialNo string) *x509.Certificate {
+	return client.certificates().get(serialNo)
+}
+
+// SignatureVerificationError is returned when a v3 response or notification
+// is not signed by WeChat Pay.
+type SignatureVerificationError struct {
+	Serial string
+	Reason string
+}
+
+func (e SignatureVerificationError) Error() string {
+	return "signature verification failed (serial " + e.Serial + "): " + e.Reason
+}
+
+// MaxSignatureAge is the maximum difference between Wechatpay-Timestamp and
+// now, signatures older than this are rejected.
+const MaxSignatureAge = 5 * time.Minute
+
+// verifyResponse verifies the Wechatpay-Signature header of a v3 response or
+// notification with the platform public key or certificate specified by the
+// Wechatpay-Serial header. Certificates are downloaded if the serial is not
+// found, see updateCertificatesForSerial.
+func (client *Client) verifyResponse(ctx context.Context, header http.Header, body []byte) error {
+	serial := header.Get("Wechatpay-Serial")
+	if strings.HasPrefix(serial, "PUB_KEY_ID_") {
+		if client.platformPublicKey == nil || serial != client.platformPublicKeyId {
+			return SignatureVerificationError{serial, "unknown platform public key"}
+		}
+		return verifySignature(client.platformPublicKey, serial, header, body)
+	}
+	cert := client.PlatformCertificate(serial)
+	if cert == nil && client.APIv3Key != "" {
+		// notifications can be forged, reject invalid ones before downloading
+		if _, err := checkSignatureHeader(serial, header); err != nil {
+			return err
+		}
+		if err := client.updateCertificatesForSerial(ctx, serial); err != nil {
+			return err
+		}
+		cert = client.PlatformCertificate(serial)
+	}
+	if cert == nil {
+		return SignatureVerificationError{serial, "platform certificate not found"}
+	}
+	return verifySignature(cert.PublicKey, serial, header, body)
+}
+
+// MinCertificatesUpdateInterval is the minimum interval between certificate
+// downloads caused by unknown serial numbers.
+const MinCertificatesUpdateInterval = time.Minute
+
+// updateCertificatesForSerial calls UpdateCertificates if the certificate of
+// serial is still not found, at most once every MinCertificatesUpdateInterval.
+// Concurrent calls wait for the same download.
+func (client *Client) updateCertificatesForSerial(ctx context.Context, serial string) error {
+	store := client.certificates()
+	store.updateMu.Lock()
+	defer store.updateMu.Unlock()
+	if store.get(serial) != nil {
+		return nil
+	}
+	if time.Since(store.updatedAt) < MinCertificatesUpdateInterval {
+		return SignatureVerificationError{serial, "platform certificate not found"}
+	}
+	store.updatedAt = time.Now()
+	return client.UpdateCertificates(ctx)
+}
+
+// checkSignatureHeader checks the format of the signature and the timestamp,
+// returns the decoded signature.
+func checkSignatureHeader(serial string, header http.Header) ([]byte, error) {
+	signature, err := base64.StdEncoding.DecodeString(header.Get("Wechatpay-Signature"))
+	if err != nil || len(signature) == 0 {
+		return nil, SignatureVerificationError{serial, "invalid signature"}
+	}
+	ts, err := strconv.ParseInt(header.Get("Wechatpay-Timestamp"), 10, 64)
+	if err != nil {
+		return nil, SignatureVerificationError{serial, "invalid timestamp"}
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return nil, SignatureVerificationError{serial, "timestamp expired"}
+	}
+	return signature, nil
+}
+
+func verifySignature(publicKey interface{}, serial string, header http.Header, body []byte) error {
+	key, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return SignatureVerificationError{serial, "public key is not RSA"}
+	}
+	signature, err := checkSignatureHeader(serial, header)
+	if err != nil {
+		return err
+	}
+	timestamp := header.Get("Wechatpay-Timestamp")
+	nonce := header.Get("Wechatpay-Nonce")
+	h := crypto.SHA256.New()
+	h.Write([]byte(timestamp + "\n" + nonce + "\n" + string(body) + "\n"))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h.Sum(nil), signature); err != nil {
+		return SignatureVerificationError{serial, "signature mismatch"}
+	}
+	return nil
+}
+
+type V3CertificatesResponse struct {
+	JsonResponse
+	Data []struct {
+		SerialNo           string              `json:"serial_no"`
+		EffectiveTime      time.Time           `json:"effective_time"`
+		ExpireTime         time.Time           `json:"expire_time"`
+		EncryptCertificate V3EncryptedResource `json:"encrypt_certificate"`
+	} `json:"data"`
+}
+
+var _ responsible = (*V3CertificatesResponse)(nil)
+
+func (r V3CertificatesResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// V3EncryptedResource is encrypted data in v3 responses and notifications.
+type V3EncryptedResource struct {
+	Algorithm      string `json:"algorithm"`
+	Nonce          string `json:"nonce"`
+	AssociatedData string `json:"associated_data"`
+	Ciphertext     string `json:"ciphertext"`
+	OriginalType   string `json:"original_type,omitempty"`
+}
+
+// decryptResource decrypts resource with AEAD_AES_256_GCM using client's
+// APIv3Key.
+func (client *Client) decryptResource(resource V3EncryptedResource) ([]byte, error) {
+	if resource.Algorithm != "AEAD_AES_256_GCM" {
+		return nil, errors.New("unsupported algorithm: " + resource.Algorithm)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(resource.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher([]byte(client.APIv3Key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(resource.Nonce))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, []byte(resource.Nonce), ciphertext, []byte(resource.AssociatedData))
+}
+
+// certificates returns the certificate store, creates one if client is not
+// created by NewClient.
+func (client *Client) certificates() *certificateStore {
+	lazyInitMu.Lock()
+	defer lazyInitMu.Unlock()
+	if client.platformCertificates == nil {
+		client.platformCertificates = newCertificateStore()
+	}
+	return client.platformCertificates
+}
+
+// certificateStore keeps platform certificates by serial number, safe for
+// concurrent use.
+type certificateStore struct {
+	mu           sync.RWMutex
+	certificates map[string]*x509.Certificate
+
+	updateMu  sync.Mutex // serializes updateCertificatesForSerial
+	updatedAt time.Time
+}
+
+func newCertificateStore() *certificateStore {
+	return &certificateStore{
+		certificates: map[string]*x509.Certificate{},
+	}
+}
+
+func (s *certificateStore) get(serialNo string) *x509.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cert := s.certificates[serialNo]
+	if cert == nil || time.Now().After(cert.NotAfter) {
+		return nil
+	}
+	return cert
+}
+
+// latest returns the valid certificate which expires last.
+func (s *certificateStore) latest() (string, *x509.Certificate) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var serialNo string
+	var latest *x509.Certificate
+	now := time.Now()
+	for serial, cert := range s.certificates {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			continue
+		}
+		if latest == nil || cert.NotAfter.After(latest.NotAfter) {
+			serialNo, latest = serial, cert
+		}
+	}
+	return serialNo, latest
+}
+
+func (s *certificateStore) set(certificates map[string]*x509.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certificates = certificates
+}
@@ -0,0 +1,229 @@
+package wxpayslim
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+const (
+	v3CombineTransactionsUrl = prefix + "/v3/combine-transactions"
+)
+
+// CreateCombineJSAPIOrderV3 creates combined order (合单) of multiple
+// sub-orders for JSAPI or mini program payment, CombinePayerInfo is required.
+// Returns PrepayId. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/combine-payment/orders/jsapi-prepay.html
+func (client *Client) CreateCombineJSAPIOrderV3(ctx context.Context, req V3CreateCombineOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createCombineOrderV3(ctx, "/jsapi", req)
+}
+
+// CreateCombineNativeOrderV3 creates combined order for Native payment,
+// returns CodeUrl to be shown as QR code. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/combine-payment/orders/native-prepay.html
+func (client *Client) CreateCombineNativeOrderV3(ctx context.Context, req V3CreateCombineOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createCombineOrderV3(ctx, "/native", req)
+}
+
+// CreateCombineAppOrderV3 creates combined order for App payment, returns
+// PrepayId. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/combine-payment/orders/app-prepay.html
+func (client *Client) CreateCombineAppOrderV3(ctx context.Context, req V3CreateCombineOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createCombineOrderV3(ctx, "/app", req)
+}
+
+// CreateCombineH5OrderV3 creates combined order for H5 payment, SceneInfo
+// with H5Info is required. Returns H5Url to redirect user to. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/combine-payment/orders/h5-prepay.html
+func (client *Client) CreateCombineH5OrderV3(ctx context.Context, req V3CreateCombineOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createCombineOrderV3(ctx, "/h5", req)
+}
+
+func (client *Client) createCombineOrderV3(ctx context.Context, path string, req V3CreateCombineOrderRequest) (*V3CreateOrderResponse, error) {
+	var res V3CreateOrderResponse
+	if err := client.postJson(ctx, v3CombineTransactionsUrl+path, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3CreateCombineOrderRequest is used in CreateCombineJSAPIOrderV3(),
+// CreateCombineNativeOrderV3(), CreateCombineAppOrderV3() and
+// CreateCombineH5OrderV3() functions.
+type V3CreateCombineOrderRequest struct {
+	CombineAppId      string              // required
+	CombineOutTradeNo string              // required, max length is 32
+	SceneInfo         *V3SceneInfo        // required for H5
+	SubOrders         []V3CombineSubOrder // required, 2 to 50 sub-orders
+	CombinePayerInfo  *V3Payer            // required for JSAPI
+	TimeStart         time.Time           // optional
+	TimeExpire        time.Time           // optional
+	NotifyUrl         string              // required
+}
+
+type V3CombineSubOrder struct {
+	MchId       string               `json:"mchid"`               // required
+	Attach      string               `json:"attach"`              // required, max length is 128
+	Amount      V3CombineAmount      `json:"amount"`              // required
+	OutTradeNo  string               `json:"out_trade_no"`        // required
+	SubMchId    string               `json:"sub_mchid,omitempty"` // required in service provider mode
+	SubAppId    string               `json:"sub_appid,omitempty"` // optional
+	Description string               `json:"description"`         // required, max length is 127
+	GoodsTag    string               `json:"goods_tag,omitempty"`
+	SettleInfo  *V3CombineSettleInfo `json:"settle_info,omitempty"`
+}
+
+type V3CombineAmount struct {
+	TotalAmount int    `json:"total_amount"` // in cents
+	Currency    string `json:"currency"`     // defaults to CNY
+}
+
+type V3CombineSettleInfo struct {
+	ProfitSharing bool `json:"profit_sharing"`
+	SubsidyAmount int  `json:"subsidy_amount,omitempty"`
+}
+
+var _ jsonRequestable = (*V3CreateCombineOrderRequest)(nil)
+
+func (r V3CreateCombineOrderRequest) toJson(client *Client) requestJson {
+	req := createCombineOrderRequestJson{}
+	req.CombineAppId = r.CombineAppId
+	req.CombineMchId = client.MchId
+	req.CombineOutTradeNo = r.CombineOutTradeNo
+	req.SceneInfo = r.SceneInfo
+	req.SubOrders = make([]V3CombineSubOrder, len(r.SubOrders))
+	for i, subOrder := range r.SubOrders {
+		if subOrder.Amount.Currency == "" {
+			subOrder.Amount.Currency = "CNY"
+		}
+		req.SubOrders[i] = subOrder
+	}
+	req.CombinePayerInfo = r.CombinePayerInfo
+	if !r.TimeStart.IsZero() {
+		req.TimeStart = r.TimeStart.Format(time.RFC3339)
+	}
+	if !r.TimeExpire.IsZero() {
+		req.TimeExpire = r.TimeExpire.Format(time.RFC3339)
+	}
+	req.NotifyUrl = r.NotifyUrl
+	return req
+}
+
+type createCombineOrderRequestJson struct {
+	CombineAppId      string              `json:"combine_appid"`
+	CombineMchId      string              `json:"combine_mchid"`
+	CombineOutTradeNo string              `json:"combine_out_trade_no"`
+	SceneInfo         *V3SceneInfo        `json:"scene_info,omitempty"`
+	SubOrders         []V3CombineSubOrder `json:"sub_orders"`
+	CombinePayerInfo  *V3Payer            `json:"combine_payer_info,omitempty"`
+	TimeStart         string              `json:"time_start,omitempty"`
+	TimeExpire        string              `json:"time_expire,omitempty"`
+	NotifyUrl         string              `json:"notify_url"`
+}
+
+// QueryCombineOrderV3 gets information of a combined order and its
+// sub-orders. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/combine-payment/orders/query-order.html
+func (client *Client) QueryCombineOrderV3(ctx context.Context, req V3QueryCombineOrderRequest) (*V3QueryCombineOrderResponse, error) {
+	var res V3QueryCombineOrderResponse
+	reqUrl := v3CombineTransactionsUrl + "/out-trade-no/" + url.PathEscape(req.CombineOutTradeNo)
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryCombineOrderRequest is used in QueryCombineOrderV3() function.
+type V3QueryCombineOrderRequest struct {
+	CombineOutTradeNo string // required
+}
+
+type V3QueryCombineOrderResponse struct {
+	JsonResponse
+	V3CombineTransaction
+}
+
+var _ responsible = (*V3QueryCombineOrderResponse)(nil)
+
+func (r V3QueryCombineOrderResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// CloseCombineOrderV3 closes an unpaid combined order and all its sub-orders.
+// Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/combine-payment/orders/close-order.html
+func (client *Client) CloseCombineOrderV3(ctx context.Context, req V3CloseCombineOrderRequest) error {
+	var res V3CloseOrderResponse
+	reqUrl := v3CombineTransactionsUrl + "/out-trade-no/" + url.PathEscape(req.CombineOutTradeNo) + "/close"
+	return client.postJson(ctx, reqUrl, req, &res)
+}
+
+// V3CloseCombineOrderRequest is used in CloseCombineOrderV3() function.
+type V3CloseCombineOrderRequest struct {
+	CombineAppId      string                   // required
+	CombineOutTradeNo string                   // required
+	SubOrders         []V3CombineCloseSubOrder // required, all sub-orders of the combined order
+}
+
+type V3CombineCloseSubOrder struct {
+	MchId      string `json:"mchid"`
+	OutTradeNo string `json:"out_trade_no"`
+	SubMchId   string `json:"sub_mchid,omitempty"` // required in service provider mode
+	SubAppId   string `json:"sub_appid,omitempty"`
+}
+
+var _ jsonRequestable = (*V3CloseCombineOrderRequest)(nil)
+
+func (r V3CloseCombineOrderRequest) toJson(client *Client) requestJson {
+	return closeCombineOrderRequestJson{
+		CombineAppId: r.CombineAppId,
+		SubOrders:    r.SubOrders,
+	}
+}
+
+type closeCombineOrderRequestJson struct {
+	CombineAppId string                   `json:"combine_appid"`
+	SubOrders    []V3CombineCloseSubOrder `json:"sub_orders"`
+}
+
+// V3CombineTransaction is the combined order in v3 notifications and
+// responses.
+type V3CombineTransaction struct {
+	CombineAppId      string `json:"combine_appid"`
+	CombineMchId      string `json:"combine_mchid"`
+	CombineOutTradeNo string `json:"combine_out_trade_no"`
+	SceneInfo         *struct {
+		DeviceId string `json:"device_id"`
+	} `json:"scene_info,omitempty"`
+	SubOrders        []V3CombineSubTransaction `json:"sub_orders"`
+	CombinePayerInfo struct {
+		OpenId string `json:"openid"`
+	} `json:"combine_payer_info"`
+}
+
+// V3CombineSubTransaction is a sub-order of combined order.
+type V3CombineSubTransaction struct {
+	MchId         string     `json:"mchid"`
+	SubMchId      string     `json:"sub_mchid,omitempty"`
+	SubAppId      string     `json:"sub_appid,omitempty"`
+	SubOpenId     string     `json:"sub_openid,omitempty"`
+	TradeType     string     `json:"trade_type"`
+	TradeState    string     `json:"trade_state"` // SUCCESS, REFUND, NOTPAY, CLOSED or PAYERROR
+	BankType      string     `json:"bank_type"`
+	Attach        string     `json:"attach"`
+	SuccessTime   *time.Time `json:"success_time,omitempty"`
+	TransactionId string     `json:"transaction_id"`
+	OutTradeNo    string     `json:"out_trade_no"`
+	Amount        struct {
+		TotalAmount   int    `json:"total_amount"`
+		PayerAmount   int    `json:"payer_amount"`
+		Currency      string `json:"currency"`
+		PayerCurrency string `json:"payer_currency"`
+	} `json:"amount"`
+	PromotionDetail []V3PromotionDetail `json:"promotion_detail,omitempty"`
+}
+
+// Check if sub-order is successfully paid.
+func (t V3CombineSubTransaction) Paid() bool {
+	return t.TradeState == "SUCCESS"
+}
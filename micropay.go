@@ -0,0 +1,276 @@
+package wxpayslim
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"time"
+)
+
+const (
+	micropayUrl     = prefix + "/pay/micropay"
+	reverseOrderUrl = prefix + "/secapi/pay/reverse"
+)
+
+// Errors returned by Micropay which mean the payment result is unknown and
+// order should be queried, can be checked with errors.Is.
+var (
+	ErrUserPaying = ResponseError{ErrCode: "USERPAYING"} // waiting for user to enter password
+	ErrBankError  = ResponseError{ErrCode: "BANKERROR"}
+)
+
+// Micropay initiates payment with the payment code (AuthCode) scanned from
+// user's WeChat. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/micropay.php?chapter=9_10&index=1
+func (client *Client) Micropay(ctx context.Context, req MicropayRequest) (*MicropayResponse, error) {
+	var res MicropayResponse
+	if err := client.postXml(ctx, micropayUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// MicropayAndWait initiates payment with Micropay. If user needs to enter
+// password (USERPAYING) or the result is unknown, order will be queried
+// repeatedly until it is paid, failed or ctx is done (30 seconds if ctx has no
+// deadline). If the order is not paid in the end, it will be reversed and the
+// error is returned. Need to set certificate (client.SetCertificate) first.
+func (client *Client) MicropayAndWait(ctx context.Context, req MicropayRequest) (*QueryOrderResponse, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+	}
+	res, err := client.Micropay(ctx, req)
+	if err == nil {
+		var order QueryOrderResponse
+		copyFields(*res, &order)
+		order.TradeState = "SUCCESS"
+		return &order, nil
+	}
+	var resErr ResponseError
+	if errors.As(err, &resErr) && resErr.ErrCode != "" &&
+		!errors.Is(err, ErrUserPaying) && !errors.Is(err, ErrSystemError) && !errors.Is(err, ErrBankError) {
+		return nil, err
+	}
+
+	query := QueryOrderRequest{
+		AppId:      req.AppId,
+		OutTradeNo: req.OutTradeNo,
+		SignType:   req.SignType,
+	}
+	wait := 2 * time.Second
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		case <-timer.C:
+			var order *QueryOrderResponse
+			order, err = client.QueryOrder(ctx, query)
+			if err != nil {
+				break
+			}
+			if order.Paid() {
+				return order, nil
+			}
+			if order.TradeState != "USERPAYING" {
+				err = ResponseError{ErrCode: order.TradeState, ErrCodeDes: order.TradeStateDesc}
+			}
+		}
+		if err != nil && errors.As(err, &resErr) && resErr.ErrCode != "" && !errors.Is(err, ErrSystemError) {
+			break
+		}
+		if ctx.Err() != nil {
+			// order may still be USERPAYING, make sure not to return nil error
+			err = ctx.Err()
+			break
+		}
+		if wait < 10*time.Second {
+			wait = wait * 3 / 2
+		}
+	}
+
+	// ctx may be done already, reverse with a new one
+	reverseCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if rerr := client.reverseUntilDone(reverseCtx, ReverseOrderRequest{
+		AppId:      req.AppId,
+		OutTradeNo: req.OutTradeNo,
+		SignType:   req.SignType,
+	}); rerr != nil {
+		return nil, rerr
+	}
+	return nil, err
+}
+
+// reverseUntilDone calls ReverseOrder again while WeChat asks to (recall is
+// Y) or system error occurs.
+func (client *Client) reverseUntilDone(ctx context.Context, req ReverseOrderRequest) error {
+	for {
+		res, err := client.ReverseOrder(ctx, req)
+		if err == nil && res.Recall != "Y" {
+			return nil
+		}
+		if err != nil && !errors.Is(err, ErrSystemError) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+type MicropayRequest struct {
+	AppId          string // required
+	DeviceInfo     string // optional
+	SignType       string // optional, either MD5 (default) or HMAC-SHA256
+	Body           string // required, max length is 127
+	Detail         string // optional, max length is 6000
+	Attach         string // optional, max length is 127
+	OutTradeNo     string // required, max length is 32
+	TotalFee       int    // required, in cents
+	FeeType        string // optional, defaults to CNY
+	SpbillCreateIp string // required, ip address of the device
+	GoodsTag       string // optional, max length is 32
+	LimitPay       string // optional, set to no_credit to disallow credit cards
+	TimeStart      string // optional, UTC+8 time format: 20060102150405
+	TimeExpire     string // optional, UTC+8 time format: 20060102150405
+	Receipt        string // optional, set to Y to enable receipt
+	AuthCode       string // required, payment code scanned from user's WeChat
+	ProfitSharing  string // optional, either Y or N (default)
+	SceneInfo      string // optional
+}
+
+var _ requestable = (*MicropayRequest)(nil)
+
+func (r MicropayRequest) toXml(client *Client) requestXml {
+	req := micropayRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type micropayRequestXml struct {
+	XMLName        xml.Name `xml:"xml"`
+	AppId          string   `xml:"appid"`
+	MchId          string   `xml:"mch_id"`
+	SubAppId       string   `xml:"sub_appid,omitempty"`
+	SubMchId       string   `xml:"sub_mch_id,omitempty"`
+	DeviceInfo     string   `xml:"device_info,omitempty"`
+	NonceStr       string   `xml:"nonce_str"`
+	Sign           string   `xml:"sign"`
+	SignType       string   `xml:"sign_type,omitempty"`
+	Body           string   `xml:"body"`
+	Detail         string   `xml:"detail,omitempty"`
+	Attach         string   `xml:"attach,omitempty"`
+	OutTradeNo     string   `xml:"out_trade_no"`
+	TotalFee       int      `xml:"total_fee"`
+	FeeType        string   `xml:"fee_type,omitempty"`
+	SpbillCreateIp string   `xml:"spbill_create_ip"`
+	GoodsTag       string   `xml:"goods_tag,omitempty"`
+	LimitPay       string   `xml:"limit_pay,omitempty"`
+	TimeStart      string   `xml:"time_start,omitempty"`
+	TimeExpire     string   `xml:"time_expire,omitempty"`
+	Receipt        string   `xml:"receipt,omitempty"`
+	AuthCode       string   `xml:"auth_code"`
+	ProfitSharing  string   `xml:"profit_sharing,omitempty"`
+	SceneInfo      string   `xml:"scene_info,omitempty"`
+}
+
+type MicropayResponse struct {
+	Response
+	AppId              string `xml:"appid,omitempty"`
+	MchId              string `xml:"mch_id,omitempty"`
+	SubAppId           string `xml:"sub_appid,omitempty"`
+	SubMchId           string `xml:"sub_mch_id,omitempty"`
+	DeviceInfo         string `xml:"device_info,omitempty"`
+	OpenId             string `xml:"openid"`
+	IsSubscribe        string `xml:"is_subscribe"`
+	SubOpenId          string `xml:"sub_openid,omitempty"`
+	SubIsSubscribe     string `xml:"sub_is_subscribe,omitempty"`
+	TradeType          string `xml:"trade_type"`
+	BankType           string `xml:"bank_type"`
+	FeeType            string `xml:"fee_type"`
+	TotalFee           int    `xml:"total_fee"`
+	SettlementTotalFee int    `xml:"settlement_total_fee"`
+	CouponFee          int    `xml:"coupon_fee"`
+	CashFeeType        string `xml:"cash_fee_type"`
+	CashFee            int    `xml:"cash_fee"`
+	TransactionId      string `xml:"transaction_id"`
+	OutTradeNo         string `xml:"out_trade_no"`
+	Attach             string `xml:"attach"`
+	TimeEnd            string `xml:"time_end"`
+}
+
+var _ responsible = (*MicropayResponse)(nil)
+
+func (r MicropayResponse) AsError() error {
+	return ResponseError(r.Response)
+}
+
+// ReverseOrder cancels a micropay order, refunds if it is paid. Need to set
+// certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/micropay.php?chapter=9_11&index=3
+func (client *Client) ReverseOrder(ctx context.Context, req ReverseOrderRequest) (*ReverseOrderResponse, error) {
+	var res ReverseOrderResponse
+	if err := client.postXml(ctx, reverseOrderUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+type ReverseOrderRequest struct {
+	AppId         string // required
+	TransactionId string // either TransactionId or OutTradeNo is required
+	OutTradeNo    string
+	SignType      string // optional, either MD5 (default) or HMAC-SHA256
+}
+
+var _ requestable = (*ReverseOrderRequest)(nil)
+
+func (r ReverseOrderRequest) toXml(client *Client) requestXml {
+	req := reverseOrderRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type reverseOrderRequestXml struct {
+	XMLName       xml.Name `xml:"xml"`
+	AppId         string   `xml:"appid"`
+	MchId         string   `xml:"mch_id"`
+	SubAppId      string   `xml:"sub_appid,omitempty"`
+	SubMchId      string   `xml:"sub_mch_id,omitempty"`
+	TransactionId string   `xml:"transaction_id,omitempty"`
+	OutTradeNo    string   `xml:"out_trade_no,omitempty"`
+	NonceStr      string   `xml:"nonce_str"`
+	Sign          string   `xml:"sign"`
+	SignType      string   `xml:"sign_type,omitempty"`
+}
+
+type ReverseOrderResponse struct {
+	Response
+	AppId    string `xml:"appid,omitempty"`
+	MchId    string `xml:"mch_id,omitempty"`
+	SubAppId string `xml:"sub_appid,omitempty"`
+	SubMchId string `xml:"sub_mch_id,omitempty"`
+	Recall   string `xml:"recall"` // Y if ReverseOrder needs to be called again
+}
+
+var _ responsible = (*ReverseOrderResponse)(nil)
+
+func (r ReverseOrderResponse) AsError() error {
+	return ResponseError(r.Response)
+}
@@ -0,0 +1,236 @@
+package wxpayslim
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// ParsePayNotify reads, verifies and parses payment notification sent to the
+// NotifyURL of CreateOrderRequest. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_7
+func (client *Client) ParsePayNotify(r *http.Request) (*PayNotification, error) {
+	var notification PayNotification
+	if err := client.parseNotify(r, &notification); err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+// PayNotifyHandler returns a http.Handler which parses payment notification
+// with ParsePayNotify and passes it to fn. WeChat will be acknowledged only if
+// fn returns nil, otherwise the notification will be sent again later.
+func (client *Client) PayNotifyHandler(fn func(context.Context, *PayNotification) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notification, err := client.ParsePayNotify(r)
+		if err == nil {
+			err = fn(r.Context(), notification)
+		}
+		writeNotifyReply(w, err)
+	})
+}
+
+type PayNotification struct {
+	Response
+	AppId              string `xml:"appid"`
+	MchId              string `xml:"mch_id"`
+	SubAppId           string `xml:"sub_appid,omitempty"`
+	SubMchId           string `xml:"sub_mch_id,omitempty"`
+	DeviceInfo         string `xml:"device_info,omitempty"`
+	SignType           string `xml:"sign_type,omitempty"`
+	OpenId             string `xml:"openid"`
+	IsSubscribe        string `xml:"is_subscribe"`
+	SubOpenId          string `xml:"sub_openid,omitempty"`
+	SubIsSubscribe     string `xml:"sub_is_subscribe,omitempty"`
+	TradeType          string `xml:"trade_type"`
+	BankType           string `xml:"bank_type"`
+	TotalFee           int    `xml:"total_fee"`
+	SettlementTotalFee int    `xml:"settlement_total_fee"`
+	FeeType            string `xml:"fee_type"`
+	CashFee            int    `xml:"cash_fee"`
+	CashFeeType        string `xml:"cash_fee_type"`
+	CouponFee          int    `xml:"coupon_fee"`
+	CouponCount        int    `xml:"coupon_count"`
+	TransactionId      string `xml:"transaction_id"`
+	OutTradeNo         string `xml:"out_trade_no"`
+	Attach             string `xml:"attach"`
+	TimeEnd            string `xml:"time_end"`
+
+	// coupons decoded from fields with _$n suffix
+	Coupons []Coupon `xml:"-"`
+}
+
+func (n *PayNotification) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain PayNotification
+	values, err := unmarshalWithValues(d, start, (*plain)(n))
+	if err != nil {
+		return err
+	}
+	n.Coupons = nil
+	return decodeIndexed(values, n.CouponCount, &n.Coupons)
+}
+
+// Check if order is successfully paid.
+func (n PayNotification) Paid() bool {
+	return n.ReturnCode == "SUCCESS" && n.ResultCode == "SUCCESS"
+}
+
+// ParseRefundNotify reads, decrypts and parses refund notification sent to
+// the NotifyURL of RefundOrderRequest. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_16&index=10
+func (client *Client) ParseRefundNotify(r *http.Request) (*RefundNotification, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if client.Debug {
+		log.Println(string(b))
+	}
+	var notification RefundNotification
+	if err := xml.Unmarshal(b, &notification); err != nil {
+		return nil, err
+	}
+	if notification.ReturnCode != "SUCCESS" {
+		return nil, ResponseError(notification.Response)
+	}
+	info, err := client.decryptReqInfo(notification.ReqInfo)
+	if err != nil {
+		return nil, err
+	}
+	if client.Debug {
+		log.Println(string(info))
+	}
+	if err := xml.Unmarshal(info, &notification); err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+// RefundNotifyHandler returns a http.Handler which parses refund notification
+// with ParseRefundNotify and passes it to fn. WeChat will be acknowledged only
+// if fn returns nil, otherwise the notification will be sent again later.
+func (client *Client) RefundNotifyHandler(fn func(context.Context, *RefundNotification) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notification, err := client.ParseRefundNotify(r)
+		if err == nil {
+			err = fn(r.Context(), notification)
+		}
+		writeNotifyReply(w, err)
+	})
+}
+
+// RefundNotification contains fields of the notification and fields
+// decrypted from its req_info.
+type RefundNotification struct {
+	Response
+	AppId    string `xml:"appid"`
+	MchId    string `xml:"mch_id"`
+	SubAppId string `xml:"sub_appid,omitempty"`
+	SubMchId string `xml:"sub_mch_id,omitempty"`
+	ReqInfo  string `xml:"req_info"`
+
+	TransactionId       string    `xml:"transaction_id"`
+	OutTradeNo          string    `xml:"out_trade_no"`
+	RefundId            string    `xml:"refund_id"`
+	OutRefundNo         string    `xml:"out_refund_no"`
+	TotalFee            int       `xml:"total_fee"`
+	SettlementTotalFee  int       `xml:"settlement_total_fee"`
+	RefundFee           int       `xml:"refund_fee"`
+	SettlementRefundFee int       `xml:"settlement_refund_fee"`
+	RefundStatus        string    `xml:"refund_status"` // SUCCESS, CHANGE or REFUNDCLOSE
+	SuccessTime         *Utc8Time `xml:"success_time"`
+	RefundRecvAccout    string    `xml:"refund_recv_accout"`
+	RefundAccount       string    `xml:"refund_account"`
+	RefundRequestSource string    `xml:"refund_request_source"`
+}
+
+// Check if order is successfully refunded.
+func (n RefundNotification) Refunded() bool {
+	return n.RefundStatus == "SUCCESS"
+}
+
+// decryptReqInfo decrypts base64 encoded req_info with AES-256-ECB, using
+// lowercase MD5 of client's key as the key.
+func (client *Client) decryptReqInfo(reqInfo string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(reqInfo)
+	if err != nil {
+		return nil, err
+	}
+	h := md5.New()
+	h.Write([]byte(client.Key))
+	block, err := aes.NewCipher([]byte(hex.EncodeToString(h.Sum(nil))))
+	if err != nil {
+		return nil, err
+	}
+	size := block.BlockSize()
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, errReqInfo
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += size {
+		block.Decrypt(out[i:i+size], data[i:i+size])
+	}
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > size || !bytes.Equal(out[len(out)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errReqInfo
+	}
+	return out[:len(out)-padding], nil
+}
+
+var errReqInfo = errors.New("invalid req_info")
+
+// parseNotify reads body of the notification request, verifies its sign
+// (using sign_type in the body) and decodes it into res.
+func (client *Client) parseNotify(r *http.Request, res interface{}) error {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if client.Debug {
+		log.Println(string(b))
+	}
+	var resp Response
+	if err := xml.Unmarshal(b, &resp); err != nil {
+		return err
+	}
+	if resp.ReturnCode != "SUCCESS" {
+		return ResponseError(resp)
+	}
+	values, err := parseXmlValues(b)
+	if err != nil {
+		return err
+	}
+	if err := client.verifySign(values, values["sign_type"]); err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, res)
+}
+
+type notifyReply struct {
+	XMLName    xml.Name `xml:"xml"`
+	ReturnCode string   `xml:"return_code"`
+	ReturnMsg  string   `xml:"return_msg,omitempty"`
+}
+
+// writeNotifyReply writes acknowledgement of a notification, SUCCESS if err
+// is nil, FAIL otherwise.
+func writeNotifyReply(w http.ResponseWriter, err error) {
+	reply := notifyReply{
+		ReturnCode: "SUCCESS",
+		ReturnMsg:  "OK",
+	}
+	if err != nil {
+		reply.ReturnCode = "FAIL"
+		reply.ReturnMsg = err.Error()
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	xml.NewEncoder(w).Encode(reply)
+}
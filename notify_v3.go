@@ -0,0 +1,168 @@
+package wxpayslim
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Event types of v3 notifications.
+const (
+	EventTransactionSuccess    = "TRANSACTION.SUCCESS"
+	EventRefundSuccess         = "REFUND.SUCCESS"
+	EventRefundAbnormal        = "REFUND.ABNORMAL"
+	EventRefundClosed          = "REFUND.CLOSED"
+	EventTransferBatchFinished = "MCHTRANSFER.BATCH.FINISHED"
+	EventTransferBatchClosed   = "MCHTRANSFER.BATCH.CLOSED"
+	EventTransferBillFinished  = "MCHTRANSFER.BILL.FINISHED"
+)
+
+// ParseNotifyV3 reads v3 notification sent to a notify URL, verifies its
+// signature with platform certificates and decrypts its resource with
+// client's APIv3Key. Use Transaction(), CombineTransaction(), Refund(),
+// TransferBatch(), TransferBill() or Decode() of the returned notification to
+// get the payload. Docs:
+// https://pay.weixin.qq.com/docs/merchant/development/interface-rules/signature-verification.html
+func (client *Client) ParseNotifyV3(r *http.Request) (*V3Notification, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if client.Debug {
+		log.Println(string(b))
+	}
+	if err := client.verifyResponse(r.Context(), r.Header, b); err != nil {
+		return nil, err
+	}
+	var notification V3Notification
+	if err := json.Unmarshal(b, &notification); err != nil {
+		return nil, err
+	}
+	notification.Plaintext, err = client.decryptResource(notification.Resource)
+	if err != nil {
+		return nil, err
+	}
+	if client.Debug {
+		log.Println(string(notification.Plaintext))
+	}
+	return &notification, nil
+}
+
+// NotifyHandlerV3 returns a http.Handler which parses v3 notification with
+// ParseNotifyV3 and passes it to fn. WeChat will be acknowledged only if fn
+// returns nil, otherwise the notification will be sent again later.
+func (client *Client) NotifyHandlerV3(fn func(context.Context, *V3Notification) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notification, err := client.ParseNotifyV3(r)
+		if err == nil {
+			err = fn(r.Context(), notification)
+		}
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", applicationJson)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(JsonResponse{
+			Code:    "FAIL",
+			Message: err.Error(),
+		})
+	})
+}
+
+type V3Notification struct {
+	Id           string              `json:"id"`
+	CreateTime   time.Time           `json:"create_time"`
+	EventType    string              `json:"event_type"`
+	ResourceType string              `json:"resource_type"`
+	Resource     V3EncryptedResource `json:"resource"`
+	Summary      string              `json:"summary"`
+
+	Plaintext []byte `json:"-"` // decrypted resource
+}
+
+// Decode unmarshals decrypted resource into v.
+func (n V3Notification) Decode(v interface{}) error {
+	return json.Unmarshal(n.Plaintext, v)
+}
+
+// Transaction returns payload of TRANSACTION.SUCCESS notification.
+func (n V3Notification) Transaction() (*V3Transaction, error) {
+	var transaction V3Transaction
+	if err := n.Decode(&transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// CombineTransaction returns payload of TRANSACTION.SUCCESS notification of
+// combined order created by CreateCombine*OrderV3.
+func (n V3Notification) CombineTransaction() (*V3CombineTransaction, error) {
+	var transaction V3CombineTransaction
+	if err := n.Decode(&transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// Refund returns payload of REFUND.* notification.
+func (n V3Notification) Refund() (*V3RefundNotification, error) {
+	var refund V3RefundNotification
+	if err := n.Decode(&refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// TransferBatch returns payload of MCHTRANSFER.BATCH.* notification.
+func (n V3Notification) TransferBatch() (*V3TransferBatchNotification, error) {
+	var batch V3TransferBatchNotification
+	if err := n.Decode(&batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// TransferBill returns payload of MCHTRANSFER.BILL.FINISHED notification.
+func (n V3Notification) TransferBill() (*V3TransferBill, error) {
+	var bill V3TransferBill
+	if err := n.Decode(&bill); err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+type V3RefundNotification struct {
+	MchId               string     `json:"mchid"`
+	OutTradeNo          string     `json:"out_trade_no"`
+	TransactionId       string     `json:"transaction_id"`
+	OutRefundNo         string     `json:"out_refund_no"`
+	RefundId            string     `json:"refund_id"`
+	RefundStatus        string     `json:"refund_status"` // SUCCESS, CLOSED or ABNORMAL
+	SuccessTime         *time.Time `json:"success_time,omitempty"`
+	UserReceivedAccount string     `json:"user_received_account"`
+	Amount              struct {
+		Total       int `json:"total"`
+		Refund      int `json:"refund"`
+		PayerTotal  int `json:"payer_total"`
+		PayerRefund int `json:"payer_refund"`
+	} `json:"amount"`
+}
+
+type V3TransferBatchNotification struct {
+	MchId         string    `json:"mchid"`
+	OutBatchNo    string    `json:"out_batch_no"`
+	BatchId       string    `json:"batch_id"`
+	BatchStatus   string    `json:"batch_status"` // FINISHED or CLOSED
+	TotalNum      int       `json:"total_num"`
+	TotalAmount   int       `json:"total_amount"`
+	SuccessAmount int       `json:"success_amount"`
+	SuccessNum    int       `json:"success_num"`
+	FailAmount    int       `json:"fail_amount"`
+	FailNum       int       `json:"fail_num"`
+	UpdateTime    time.Time `json:"update_time"`
+	CloseReason   string    `json:"close_reason,omitempty"`
+}
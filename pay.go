@@ -10,6 +10,7 @@ const (
 	queryOrderUrl  = prefix + "/pay/orderquery"
 	refundOrderUrl = prefix + "/secapi/pay/refund"
 	queryRefundUrl = prefix + "/pay/refundquery"
+	closeOrderUrl  = prefix + "/pay/closeorder"
 )
 
 // CreateOrder initiates payment.
@@ -40,6 +41,7 @@ type CreateOrderRequest struct {
 	ProductId      string // required if TradeType == NATIVE, max length is 32
 	LimitPay       string // optional, set to no_credit to disallow credit cards
 	OpenId         string // required if TradeType == JSAPI
+	SubOpenId      string // service provider mode only, openid under client's SubAppId, can be used instead of OpenId
 	Receipt        string // optional, set to Y to enable receipt
 	ProfitSharing  string // optional, either Y or N (default)
 	SceneInfo      string // optional
@@ -51,6 +53,8 @@ func (r CreateOrderRequest) toXml(client *Client) requestXml {
 	req := createOrderRequestXml{}
 	copyFields(r, &req)
 	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
 	req.NonceStr = randomStr(32)
 	req.Sign = client.generateSign(req)
 	return req
@@ -60,6 +64,8 @@ type createOrderRequestXml struct {
 	XMLName        xml.Name `xml:"xml"`
 	AppId          string   `xml:"appid"`
 	MchId          string   `xml:"mch_id"`
+	SubAppId       string   `xml:"sub_appid,omitempty"`
+	SubMchId       string   `xml:"sub_mch_id,omitempty"`
 	DeviceInfo     string   `xml:"device_info,omitempty"`
 	NonceStr       string   `xml:"nonce_str"`
 	Sign           string   `xml:"sign"`
@@ -79,6 +85,7 @@ type createOrderRequestXml struct {
 	ProductId      string   `xml:"product_id,omitempty"`
 	LimitPay       string   `xml:"limit_pay,omitempty"`
 	OpenId         string   `xml:"openid,omitempty"`
+	SubOpenId      string   `xml:"sub_openid,omitempty"`
 	Receipt        string   `xml:"receipt,omitempty"`
 	ProfitSharing  string   `xml:"profit_sharing,omitempty"`
 	SceneInfo      string   `xml:"scene_info,omitempty"`
@@ -88,6 +95,8 @@ type CreateOrderResponse struct {
 	Response
 	AppId      string `xml:"mch_appid,omitempty"`
 	MchId      string `xml:"mchid,omitempty"`
+	SubAppId   string `xml:"sub_appid,omitempty"`
+	SubMchId   string `xml:"sub_mch_id,omitempty"`
 	DeviceInfo string `xml:"device_info,omitempty"`
 	TradeType  string `xml:"trade_type"`
 	PrepayId   string `xml:"prepay_id"`
@@ -113,6 +122,7 @@ type QueryOrderRequest struct {
 	AppId         string // required
 	TransactionId string // either TransactionId or OutTradeNo is required
 	OutTradeNo    string
+	SignType      string // optional, either MD5 (default) or HMAC-SHA256
 }
 
 var _ requestable = (*QueryOrderRequest)(nil)
@@ -121,6 +131,8 @@ func (r QueryOrderRequest) toXml(client *Client) requestXml {
 	req := queryOrderRequestXml{}
 	copyFields(r, &req)
 	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
 	req.NonceStr = randomStr(32)
 	req.Sign = client.generateSign(req)
 	return req
@@ -130,6 +142,8 @@ type queryOrderRequestXml struct {
 	XMLName       xml.Name `xml:"xml"`
 	AppId         string   `xml:"appid"`
 	MchId         string   `xml:"mch_id"`
+	SubAppId      string   `xml:"sub_appid,omitempty"`
+	SubMchId      string   `xml:"sub_mch_id,omitempty"`
 	TransactionId string   `xml:"transaction_id,omitempty"`
 	OutTradeNo    string   `xml:"out_trade_no,omitempty"`
 	NonceStr      string   `xml:"nonce_str"`
@@ -139,12 +153,16 @@ type queryOrderRequestXml struct {
 
 type QueryOrderResponse struct {
 	Response
-	AppId string `xml:"appid,omitempty"`
-	MchId string `xml:"mch_id,omitempty"`
+	AppId    string `xml:"appid,omitempty"`
+	MchId    string `xml:"mch_id,omitempty"`
+	SubAppId string `xml:"sub_appid,omitempty"`
+	SubMchId string `xml:"sub_mch_id,omitempty"`
 
 	DeviceInfo         string `xml:"device_info,omitempty"`
 	OpenId             string `xml:"openid"`
 	IsSubscribe        string `xml:"is_subscribe"`
+	SubOpenId          string `xml:"sub_openid,omitempty"`
+	SubIsSubscribe     string `xml:"sub_is_subscribe,omitempty"`
 	TradeType          string `xml:"trade_type"`
 	TradeState         string `xml:"trade_state"`
 	BankType           string `xml:"bank_type"`
@@ -160,6 +178,25 @@ type QueryOrderResponse struct {
 	Attach             string `xml:"attach"`
 	TimeEnd            string `xml:"time_end"`
 	TradeStateDesc     string `xml:"trade_state_desc"`
+
+	// coupons decoded from fields with _$n suffix
+	Coupons []Coupon `xml:"-"`
+}
+
+type Coupon struct {
+	CouponType string `indexed:"coupon_type_$n"` // CASH or NO_CASH
+	CouponId   string `indexed:"coupon_id_$n"`
+	CouponFee  int    `indexed:"coupon_fee_$n"`
+}
+
+func (r *QueryOrderResponse) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain QueryOrderResponse
+	values, err := unmarshalWithValues(d, start, (*plain)(r))
+	if err != nil {
+		return err
+	}
+	r.Coupons = nil
+	return decodeIndexed(values, r.CouponCount, &r.Coupons)
 }
 
 var _ responsible = (*QueryOrderResponse)(nil)
@@ -202,6 +239,8 @@ func (r RefundOrderRequest) toXml(client *Client) requestXml {
 	req := refundOrderRequestXml{}
 	copyFields(r, &req)
 	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
 	req.NonceStr = randomStr(32)
 	req.Sign = client.generateSign(req)
 	return req
@@ -211,6 +250,8 @@ type refundOrderRequestXml struct {
 	XMLName       xml.Name `xml:"xml"`
 	AppId         string   `xml:"appid"`
 	MchId         string   `xml:"mch_id"`
+	SubAppId      string   `xml:"sub_appid,omitempty"`
+	SubMchId      string   `xml:"sub_mch_id,omitempty"`
 	NonceStr      string   `xml:"nonce_str"`
 	Sign          string   `xml:"sign"`
 	SignType      string   `xml:"sign_type,omitempty"`
@@ -229,6 +270,8 @@ type RefundOrderResponse struct {
 	Response
 	AppId               string `xml:"appid,omitempty"`
 	MchId               string `xml:"mch_id,omitempty"`
+	SubAppId            string `xml:"sub_appid,omitempty"`
+	SubMchId            string `xml:"sub_mch_id,omitempty"`
 	TransactionId       string `xml:"transaction_id"`
 	OutTradeNo          string `xml:"out_trade_no"`
 	OutRefundNo         string `xml:"out_refund_no"`
@@ -258,6 +301,29 @@ func (client *Client) QueryRefundOrder(ctx context.Context, req QueryRefundOrder
 	return &res, nil
 }
 
+// QueryAllRefundOrders is like QueryRefundOrder, but if an order has more
+// than 10 refunds, it queries again with Offset until all refunds are
+// retrieved in Refunds of the returned response.
+func (client *Client) QueryAllRefundOrders(ctx context.Context, req QueryRefundOrderRequest) (*QueryRefundOrderResponse, error) {
+	res, err := client.QueryRefundOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	offset := req.Offset
+	for len(res.Refunds) < res.TotalRefundCount {
+		req.Offset = offset + len(res.Refunds)
+		next, err := client.QueryRefundOrder(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if len(next.Refunds) == 0 {
+			break
+		}
+		res.Refunds = append(res.Refunds, next.Refunds...)
+	}
+	return res, nil
+}
+
 type QueryRefundOrderRequest struct {
 	AppId         string // required
 	TransactionId string // either TransactionId, OutTradeNo, OutRefundNo or RefundId is required
@@ -273,6 +339,8 @@ func (r QueryRefundOrderRequest) toXml(client *Client) requestXml {
 	req := queryRefundOrderRequestXml{}
 	copyFields(r, &req)
 	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
 	req.NonceStr = randomStr(32)
 	req.Sign = client.generateSign(req)
 	return req
@@ -282,6 +350,8 @@ type queryRefundOrderRequestXml struct {
 	XMLName       xml.Name `xml:"xml"`
 	AppId         string   `xml:"appid"`
 	MchId         string   `xml:"mch_id"`
+	SubAppId      string   `xml:"sub_appid,omitempty"`
+	SubMchId      string   `xml:"sub_mch_id,omitempty"`
 	NonceStr      string   `xml:"nonce_str"`
 	Sign          string   `xml:"sign"`
 	SignType      string   `xml:"sign_type,omitempty"`
@@ -294,8 +364,10 @@ type queryRefundOrderRequestXml struct {
 
 type QueryRefundOrderResponse struct {
 	Response
-	AppId string `xml:"appid"`
-	MchId string `xml:"mch_id"`
+	AppId    string `xml:"appid"`
+	MchId    string `xml:"mch_id"`
+	SubAppId string `xml:"sub_appid,omitempty"`
+	SubMchId string `xml:"sub_mch_id,omitempty"`
 
 	TotalRefundCount     int    `xml:"total_refund_count"`
 	TransactionId        string `xml:"transaction_id"`
@@ -317,6 +389,40 @@ type QueryRefundOrderResponse struct {
 	RefundRecvAccout0    string `xml:"refund_recv_accout_0"`
 	RefundSuccessTime0   string `xml:"refund_success_time_0"`
 	CashRefundFee        int    `xml:"cash_refund_fee"`
+
+	// all refunds decoded from fields with _$n suffix
+	Refunds []RefundDetail `xml:"-"`
+}
+
+type RefundDetail struct {
+	OutRefundNo         string         `indexed:"out_refund_no_$n"`
+	RefundId            string         `indexed:"refund_id_$n"`
+	RefundChannel       string         `indexed:"refund_channel_$n"`
+	RefundFee           int            `indexed:"refund_fee_$n"`
+	SettlementRefundFee int            `indexed:"settlement_refund_fee_$n"`
+	CouponRefundFee     int            `indexed:"coupon_refund_fee_$n"`
+	CouponRefundCount   int            `indexed:"coupon_refund_count_$n"`
+	Coupons             []RefundCoupon `indexed:"coupon_refund_count_$n"`
+	RefundStatus        string         `indexed:"refund_status_$n"` // SUCCESS, REFUNDCLOSE, PROCESSING or CHANGE
+	RefundAccount       string         `indexed:"refund_account_$n"`
+	RefundRecvAccout    string         `indexed:"refund_recv_accout_$n"`
+	RefundSuccessTime   string         `indexed:"refund_success_time_$n"`
+}
+
+type RefundCoupon struct {
+	CouponType      string `indexed:"coupon_type_$n_$m"`
+	CouponRefundId  string `indexed:"coupon_refund_id_$n_$m"`
+	CouponRefundFee int    `indexed:"coupon_refund_fee_$n_$m"`
+}
+
+func (r *QueryRefundOrderResponse) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain QueryRefundOrderResponse
+	values, err := unmarshalWithValues(d, start, (*plain)(r))
+	if err != nil {
+		return err
+	}
+	r.Refunds = nil
+	return decodeIndexed(values, r.RefundCount, &r.Refunds)
 }
 
 var _ responsible = (*QueryRefundOrderResponse)(nil)
@@ -329,3 +435,65 @@ func (r QueryRefundOrderResponse) AsError() error {
 func (r QueryRefundOrderResponse) Refunded() bool {
 	return r.ReturnCode == "SUCCESS" && r.ResultCode == "SUCCESS" && r.RefundStatus0 == "SUCCESS"
 }
+
+// Errors returned by CloseOrder, can be checked with errors.Is.
+var (
+	ErrOrderPaid   = ResponseError{ErrCode: "ORDERPAID"}   // order is paid, should refund instead
+	ErrOrderClosed = ResponseError{ErrCode: "ORDERCLOSED"} // order is already closed
+	ErrSystemError = ResponseError{ErrCode: "SYSTEMERROR"} // should retry with the same request
+)
+
+// CloseOrder closes an unpaid order by Trade No. Order can not be closed
+// within 5 minutes after created.
+func (client *Client) CloseOrder(ctx context.Context, req CloseOrderRequest) (*CloseOrderResponse, error) {
+	var res CloseOrderResponse
+	if err := client.postXml(ctx, closeOrderUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+type CloseOrderRequest struct {
+	AppId      string // required
+	OutTradeNo string // required
+	SignType   string // optional, either MD5 (default) or HMAC-SHA256
+}
+
+var _ requestable = (*CloseOrderRequest)(nil)
+
+func (r CloseOrderRequest) toXml(client *Client) requestXml {
+	req := closeOrderRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type closeOrderRequestXml struct {
+	XMLName    xml.Name `xml:"xml"`
+	AppId      string   `xml:"appid"`
+	MchId      string   `xml:"mch_id"`
+	SubAppId   string   `xml:"sub_appid,omitempty"`
+	SubMchId   string   `xml:"sub_mch_id,omitempty"`
+	OutTradeNo string   `xml:"out_trade_no"`
+	NonceStr   string   `xml:"nonce_str"`
+	Sign       string   `xml:"sign"`
+	SignType   string   `xml:"sign_type,omitempty"`
+}
+
+type CloseOrderResponse struct {
+	Response
+	AppId    string `xml:"appid,omitempty"`
+	MchId    string `xml:"mch_id,omitempty"`
+	SubAppId string `xml:"sub_appid,omitempty"`
+	SubMchId string `xml:"sub_mch_id,omitempty"`
+}
+
+var _ responsible = (*CloseOrderResponse)(nil)
+
+func (r CloseOrderResponse) AsError() error {
+	return ResponseError(r.Response)
+}
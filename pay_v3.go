@@ -0,0 +1,388 @@
+package wxpayslim
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+const (
+	v3TransactionsUrl        = prefix + "/v3/pay/transactions"
+	v3PartnerTransactionsUrl = prefix + "/v3/pay/partner/transactions"
+)
+
+// transactionsUrlV3 returns base URL of v3 payment APIs, partner APIs are used
+// in service provider mode (client.SubMerchant).
+func (client *Client) transactionsUrlV3() string {
+	if client.SubMchId != "" {
+		return v3PartnerTransactionsUrl
+	}
+	return v3TransactionsUrl
+}
+
+// merchantQueryV3 returns query string of merchant ids for v3 payment APIs.
+func (client *Client) merchantQueryV3() string {
+	query := url.Values{}
+	if client.SubMchId != "" {
+		query.Set("sp_mchid", client.MchId)
+		query.Set("sub_mchid", client.SubMchId)
+	} else {
+		query.Set("mchid", client.MchId)
+	}
+	return query.Encode()
+}
+
+// CreateJSAPIOrderV3 creates order for JSAPI or mini program payment, Payer
+// is required. Returns PrepayId. Orders of sub-merchant are created with
+// client.SubMerchant(). Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/direct-jsons/jsapi-prepay.html
+func (client *Client) CreateJSAPIOrderV3(ctx context.Context, req V3CreateOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createOrderV3(ctx, "/jsapi", req)
+}
+
+// CreateNativeOrderV3 creates order for Native payment, returns CodeUrl to be
+// shown as QR code. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/native-payment/direct-jsons/native-prepay.html
+func (client *Client) CreateNativeOrderV3(ctx context.Context, req V3CreateOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createOrderV3(ctx, "/native", req)
+}
+
+// CreateAppOrderV3 creates order for App payment, returns PrepayId. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/in-app-payment/direct-jsons/app-prepay.html
+func (client *Client) CreateAppOrderV3(ctx context.Context, req V3CreateOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createOrderV3(ctx, "/app", req)
+}
+
+// CreateH5OrderV3 creates order for H5 payment, SceneInfo with H5Info is
+// required. Returns H5Url to redirect user to. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/h5-payment/direct-jsons/h5-prepay.html
+func (client *Client) CreateH5OrderV3(ctx context.Context, req V3CreateOrderRequest) (*V3CreateOrderResponse, error) {
+	return client.createOrderV3(ctx, "/h5", req)
+}
+
+func (client *Client) createOrderV3(ctx context.Context, path string, req V3CreateOrderRequest) (*V3CreateOrderResponse, error) {
+	var res V3CreateOrderResponse
+	if err := client.postJson(ctx, client.transactionsUrlV3()+path, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3CreateOrderRequest is used in CreateJSAPIOrderV3(), CreateNativeOrderV3(),
+// CreateAppOrderV3() and CreateH5OrderV3() functions.
+type V3CreateOrderRequest struct {
+	AppId         string         // required, AppId of service provider in service provider mode
+	Description   string         // required, max length is 127
+	OutTradeNo    string         // required, max length is 32, min length is 6
+	TimeExpire    time.Time      // optional
+	Attach        string         // optional, max length is 128
+	NotifyUrl     string         // required
+	GoodsTag      string         // optional
+	SupportFapiao bool           // optional
+	Amount        V3Amount       // required
+	Payer         *V3Payer       // required for JSAPI
+	Detail        *V3OrderDetail // optional
+	SceneInfo     *V3SceneInfo   // required for H5
+	SettleInfo    *V3SettleInfo  // optional
+}
+
+type V3Amount struct {
+	Total    int    `json:"total"`              // in cents
+	Currency string `json:"currency,omitempty"` // defaults to CNY
+}
+
+type V3Payer struct {
+	OpenId    string `json:"openid,omitempty"`
+	SubOpenId string `json:"sub_openid,omitempty"` // service provider mode only, openid under client's SubAppId
+}
+
+type V3OrderDetail struct {
+	CostPrice   int             `json:"cost_price,omitempty"`
+	InvoiceId   string          `json:"invoice_id,omitempty"`
+	GoodsDetail []V3GoodsDetail `json:"goods_detail,omitempty"`
+}
+
+type V3GoodsDetail struct {
+	MerchantGoodsId  string `json:"merchant_goods_id"`
+	WechatpayGoodsId string `json:"wechatpay_goods_id,omitempty"`
+	GoodsName        string `json:"goods_name,omitempty"`
+	Quantity         int    `json:"quantity"`
+	UnitPrice        int    `json:"unit_price"`
+}
+
+type V3SceneInfo struct {
+	PayerClientIp string       `json:"payer_client_ip"`
+	DeviceId      string       `json:"device_id,omitempty"`
+	StoreInfo     *V3StoreInfo `json:"store_info,omitempty"`
+	H5Info        *V3H5Info    `json:"h5_info,omitempty"`
+}
+
+type V3StoreInfo struct {
+	Id       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	AreaCode string `json:"area_code,omitempty"`
+	Address  string `json:"address,omitempty"`
+}
+
+type V3H5Info struct {
+	Type        string `json:"type"` // iOS, Android or Wap
+	AppName     string `json:"app_name,omitempty"`
+	AppUrl      string `json:"app_url,omitempty"`
+	BundleId    string `json:"bundle_id,omitempty"`
+	PackageName string `json:"package_name,omitempty"`
+}
+
+type V3SettleInfo struct {
+	ProfitSharing bool `json:"profit_sharing"`
+}
+
+var _ jsonRequestable = (*V3CreateOrderRequest)(nil)
+
+func (r V3CreateOrderRequest) toJson(client *Client) requestJson {
+	if client.SubMchId != "" {
+		return r.toPartnerJson(client)
+	}
+	req := createOrderRequestJson{}
+	req.AppId = r.AppId
+	req.MchId = client.MchId
+	req.Description = r.Description
+	req.OutTradeNo = r.OutTradeNo
+	if !r.TimeExpire.IsZero() {
+		req.TimeExpire = r.TimeExpire.Format(time.RFC3339)
+	}
+	req.Attach = r.Attach
+	req.NotifyUrl = r.NotifyUrl
+	req.GoodsTag = r.GoodsTag
+	req.SupportFapiao = r.SupportFapiao
+	req.Amount = r.Amount
+	req.Payer = r.Payer
+	req.Detail = r.Detail
+	req.SceneInfo = r.SceneInfo
+	req.SettleInfo = r.SettleInfo
+	return req
+}
+
+type createOrderRequestJson struct {
+	AppId         string         `json:"appid"`
+	MchId         string         `json:"mchid"`
+	Description   string         `json:"description"`
+	OutTradeNo    string         `json:"out_trade_no"`
+	TimeExpire    string         `json:"time_expire,omitempty"`
+	Attach        string         `json:"attach,omitempty"`
+	NotifyUrl     string         `json:"notify_url"`
+	GoodsTag      string         `json:"goods_tag,omitempty"`
+	SupportFapiao bool           `json:"support_fapiao,omitempty"`
+	Amount        V3Amount       `json:"amount"`
+	Payer         *V3Payer       `json:"payer,omitempty"`
+	Detail        *V3OrderDetail `json:"detail,omitempty"`
+	SceneInfo     *V3SceneInfo   `json:"scene_info,omitempty"`
+	SettleInfo    *V3SettleInfo  `json:"settle_info,omitempty"`
+}
+
+func (r V3CreateOrderRequest) toPartnerJson(client *Client) requestJson {
+	req := partnerCreateOrderRequestJson{}
+	req.SpAppId = r.AppId
+	req.SpMchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
+	req.Description = r.Description
+	req.OutTradeNo = r.OutTradeNo
+	if !r.TimeExpire.IsZero() {
+		req.TimeExpire = r.TimeExpire.Format(time.RFC3339)
+	}
+	req.Attach = r.Attach
+	req.NotifyUrl = r.NotifyUrl
+	req.GoodsTag = r.GoodsTag
+	req.SupportFapiao = r.SupportFapiao
+	req.Amount = r.Amount
+	if r.Payer != nil {
+		req.Payer = &partnerPayerJson{
+			SpOpenId:  r.Payer.OpenId,
+			SubOpenId: r.Payer.SubOpenId,
+		}
+	}
+	req.Detail = r.Detail
+	req.SceneInfo = r.SceneInfo
+	req.SettleInfo = r.SettleInfo
+	return req
+}
+
+type partnerCreateOrderRequestJson struct {
+	SpAppId       string            `json:"sp_appid"`
+	SpMchId       string            `json:"sp_mchid"`
+	SubAppId      string            `json:"sub_appid,omitempty"`
+	SubMchId      string            `json:"sub_mchid"`
+	Description   string            `json:"description"`
+	OutTradeNo    string            `json:"out_trade_no"`
+	TimeExpire    string            `json:"time_expire,omitempty"`
+	Attach        string            `json:"attach,omitempty"`
+	NotifyUrl     string            `json:"notify_url"`
+	GoodsTag      string            `json:"goods_tag,omitempty"`
+	SupportFapiao bool              `json:"support_fapiao,omitempty"`
+	Amount        V3Amount          `json:"amount"`
+	Payer         *partnerPayerJson `json:"payer,omitempty"`
+	Detail        *V3OrderDetail    `json:"detail,omitempty"`
+	SceneInfo     *V3SceneInfo      `json:"scene_info,omitempty"`
+	SettleInfo    *V3SettleInfo     `json:"settle_info,omitempty"`
+}
+
+type partnerPayerJson struct {
+	SpOpenId  string `json:"sp_openid,omitempty"`
+	SubOpenId string `json:"sub_openid,omitempty"`
+}
+
+type V3CreateOrderResponse struct {
+	JsonResponse
+	PrepayId string `json:"prepay_id,omitempty"` // JSAPI and App
+	CodeUrl  string `json:"code_url,omitempty"`  // Native
+	H5Url    string `json:"h5_url,omitempty"`    // H5
+}
+
+var _ responsible = (*V3CreateOrderResponse)(nil)
+
+func (r V3CreateOrderResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// QueryOrderV3 gets information of an order by Transaction ID or Trade No.
+// Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/query-by-out-trade-no.html
+func (client *Client) QueryOrderV3(ctx context.Context, req V3QueryOrderRequest) (*V3QueryOrderResponse, error) {
+	reqUrl := client.transactionsUrlV3()
+	if req.TransactionId != "" {
+		reqUrl += "/id/" + url.PathEscape(req.TransactionId)
+	} else {
+		reqUrl += "/out-trade-no/" + url.PathEscape(req.OutTradeNo)
+	}
+	reqUrl += "?" + client.merchantQueryV3()
+	var res V3QueryOrderResponse
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryOrderRequest is used in QueryOrderV3() function.
+type V3QueryOrderRequest struct {
+	TransactionId string // either TransactionId or OutTradeNo is required
+	OutTradeNo    string
+}
+
+type V3QueryOrderResponse struct {
+	JsonResponse
+	V3Transaction
+}
+
+var _ responsible = (*V3QueryOrderResponse)(nil)
+
+func (r V3QueryOrderResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// CloseOrderV3 closes an unpaid order by Trade No. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/close-order.html
+func (client *Client) CloseOrderV3(ctx context.Context, req V3CloseOrderRequest) error {
+	var res V3CloseOrderResponse
+	reqUrl := client.transactionsUrlV3() + "/out-trade-no/" + url.PathEscape(req.OutTradeNo) + "/close"
+	return client.postJson(ctx, reqUrl, req, &res)
+}
+
+// V3CloseOrderRequest is used in CloseOrderV3() function.
+type V3CloseOrderRequest struct {
+	OutTradeNo string // required
+}
+
+var _ jsonRequestable = (*V3CloseOrderRequest)(nil)
+
+func (r V3CloseOrderRequest) toJson(client *Client) requestJson {
+	if client.SubMchId != "" {
+		return partnerCloseOrderRequestJson{
+			SpMchId:  client.MchId,
+			SubMchId: client.SubMchId,
+		}
+	}
+	return closeOrderRequestJson{
+		MchId: client.MchId,
+	}
+}
+
+type closeOrderRequestJson struct {
+	MchId string `json:"mchid"`
+}
+
+type partnerCloseOrderRequestJson struct {
+	SpMchId  string `json:"sp_mchid"`
+	SubMchId string `json:"sub_mchid"`
+}
+
+// V3CloseOrderResponse is empty on success.
+type V3CloseOrderResponse struct {
+	JsonResponse
+}
+
+var _ responsible = (*V3CloseOrderResponse)(nil)
+
+func (r V3CloseOrderResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// V3Transaction is the payment order in v3 notifications and responses. In
+// service provider mode, AppId and MchId are empty, SpAppId, SpMchId,
+// SubAppId and SubMchId are set instead.
+type V3Transaction struct {
+	AppId          string     `json:"appid,omitempty"`
+	MchId          string     `json:"mchid,omitempty"`
+	SpAppId        string     `json:"sp_appid,omitempty"`
+	SpMchId        string     `json:"sp_mchid,omitempty"`
+	SubAppId       string     `json:"sub_appid,omitempty"`
+	SubMchId       string     `json:"sub_mchid,omitempty"`
+	OutTradeNo     string     `json:"out_trade_no"`
+	TransactionId  string     `json:"transaction_id"`
+	TradeType      string     `json:"trade_type"`  // JSAPI, NATIVE, APP, MICROPAY, MWEB or FACEPAY
+	TradeState     string     `json:"trade_state"` // SUCCESS, REFUND, NOTPAY, CLOSED, REVOKED, USERPAYING or PAYERROR
+	TradeStateDesc string     `json:"trade_state_desc"`
+	BankType       string     `json:"bank_type"`
+	Attach         string     `json:"attach"`
+	SuccessTime    *time.Time `json:"success_time,omitempty"`
+	Payer          struct {
+		OpenId    string `json:"openid,omitempty"`
+		SpOpenId  string `json:"sp_openid,omitempty"`
+		SubOpenId string `json:"sub_openid,omitempty"`
+	} `json:"payer"`
+	Amount struct {
+		Total         int    `json:"total"`
+		PayerTotal    int    `json:"payer_total"`
+		Currency      string `json:"currency"`
+		PayerCurrency string `json:"payer_currency"`
+	} `json:"amount"`
+	SceneInfo *struct {
+		DeviceId string `json:"device_id"`
+	} `json:"scene_info,omitempty"`
+	PromotionDetail []V3PromotionDetail `json:"promotion_detail,omitempty"`
+}
+
+// V3PromotionDetail is a coupon used in the payment.
+type V3PromotionDetail struct {
+	CouponId            string `json:"coupon_id"`
+	Name                string `json:"name"`
+	Scope               string `json:"scope"` // GLOBAL or SINGLE
+	Type                string `json:"type"`  // CASH or NOCASH
+	Amount              int    `json:"amount"`
+	StockId             string `json:"stock_id"`
+	WechatpayContribute int    `json:"wechatpay_contribute"`
+	MerchantContribute  int    `json:"merchant_contribute"`
+	OtherContribute     int    `json:"other_contribute"`
+	Currency            string `json:"currency"`
+	GoodsDetail         []struct {
+		GoodsId        string `json:"goods_id"`
+		Quantity       int    `json:"quantity"`
+		UnitPrice      int    `json:"unit_price"`
+		DiscountAmount int    `json:"discount_amount"`
+		GoodsRemark    string `json:"goods_remark"`
+	} `json:"goods_detail,omitempty"`
+}
+
+// Check if order is successfully paid.
+func (t V3Transaction) Paid() bool {
+	return t.TradeState == "SUCCESS"
+}
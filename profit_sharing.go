@@ -0,0 +1,455 @@
+package wxpayslim
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+)
+
+const (
+	addProfitSharingReceiverUrl    = prefix + "/pay/profitsharingaddreceiver"
+	removeProfitSharingReceiverUrl = prefix + "/pay/profitsharingremovereceiver"
+	profitSharingUrl               = prefix + "/secapi/pay/profitsharing"
+	multiProfitSharingUrl          = prefix + "/secapi/pay/multiprofitsharing"
+	queryProfitSharingUrl          = prefix + "/pay/profitsharingquery"
+	finishProfitSharingUrl         = prefix + "/secapi/pay/profitsharingfinish"
+	returnProfitSharingUrl         = prefix + "/secapi/pay/profitsharingreturn"
+	queryProfitSharingReturnUrl    = prefix + "/pay/profitsharingreturnquery"
+)
+
+// Types of profit sharing receivers.
+const (
+	ProfitSharingReceiverMerchant          = "MERCHANT_ID"
+	ProfitSharingReceiverPersonalOpenId    = "PERSONAL_OPENID"
+	ProfitSharingReceiverPersonalSubOpenId = "PERSONAL_SUB_OPENID"
+)
+
+// ProfitSharingReceiver is the receiver in AddProfitSharingReceiver() and
+// RemoveProfitSharingReceiver() functions.
+type ProfitSharingReceiver struct {
+	Type           string `json:"type"`                      // required, see ProfitSharingReceiver* constants
+	Account        string `json:"account"`                   // required, merchant id or openid
+	Name           string `json:"name,omitempty"`            // required if Type is MERCHANT_ID
+	RelationType   string `json:"relation_type,omitempty"`   // required when adding, like SERVICE_PROVIDER, STORE, STAFF or CUSTOM
+	CustomRelation string `json:"custom_relation,omitempty"` // required if RelationType is CUSTOM
+}
+
+// UnmarshalXML decodes receiver which is JSON encoded in XML responses.
+func (r *ProfitSharingReceiver) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	return unmarshalJsonInXml(d, start, (*profitSharingReceiver)(r))
+}
+
+type profitSharingReceiver ProfitSharingReceiver
+
+// ProfitSharingAmount is the amount shared to a receiver.
+type ProfitSharingAmount struct {
+	Type        string `json:"type"`    // required, see ProfitSharingReceiver* constants
+	Account     string `json:"account"` // required, merchant id or openid
+	Amount      int    `json:"amount"`  // required, in cents
+	Description string `json:"description"`
+
+	// only available in QueryProfitSharing() responses
+	Result     string `json:"result,omitempty"`      // PENDING, SUCCESS or CLOSED
+	FinishTime string `json:"finish_time,omitempty"` // UTC+8 time format: 20060102150405
+	FailReason string `json:"fail_reason,omitempty"`
+}
+
+// ProfitSharingAmounts is the list of receivers which is JSON encoded in XML
+// requests and responses.
+type ProfitSharingAmounts []ProfitSharingAmount
+
+func (a ProfitSharingAmounts) String() string {
+	b, _ := json.Marshal([]ProfitSharingAmount(a))
+	return string(b)
+}
+
+func (a *ProfitSharingAmounts) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	return unmarshalJsonInXml(d, start, (*[]ProfitSharingAmount)(a))
+}
+
+func unmarshalJsonInXml(d *xml.Decoder, start xml.StartElement, v interface{}) error {
+	var value string
+	if err := d.DecodeElement(&value, &start); err != nil {
+		return err
+	}
+	if value == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+// Add receiver before sharing profit to it. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_3&index=4
+func (client *Client) AddProfitSharingReceiver(ctx context.Context, req ProfitSharingReceiverRequest) (*ProfitSharingReceiverResponse, error) {
+	var res ProfitSharingReceiverResponse
+	if err := client.postXml(ctx, addProfitSharingReceiverUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Remove receiver added by AddProfitSharingReceiver. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_4&index=5
+func (client *Client) RemoveProfitSharingReceiver(ctx context.Context, req ProfitSharingReceiverRequest) (*ProfitSharingReceiverResponse, error) {
+	var res ProfitSharingReceiverResponse
+	if err := client.postXml(ctx, removeProfitSharingReceiverUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ProfitSharingReceiverRequest is used in AddProfitSharingReceiver() and
+// RemoveProfitSharingReceiver() functions.
+type ProfitSharingReceiverRequest struct {
+	AppId    string                // required
+	Receiver ProfitSharingReceiver // required
+}
+
+var _ requestable = (*ProfitSharingReceiverRequest)(nil)
+
+func (r ProfitSharingReceiverRequest) toXml(client *Client) requestXml {
+	req := profitSharingReceiverRequestXml{}
+	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
+	req.AppId = r.AppId
+	req.NonceStr = randomStr(32)
+	req.SignType = "HMAC-SHA256" // only HMAC-SHA256 is supported
+	receiver, _ := json.Marshal(r.Receiver)
+	req.Receiver = string(receiver)
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type profitSharingReceiverRequestXml struct {
+	XMLName  xml.Name `xml:"xml"`
+	MchId    string   `xml:"mch_id"`
+	SubAppId string   `xml:"sub_appid,omitempty"`
+	SubMchId string   `xml:"sub_mch_id,omitempty"`
+	AppId    string   `xml:"appid"`
+	NonceStr string   `xml:"nonce_str"`
+	Sign     string   `xml:"sign"`
+	SignType string   `xml:"sign_type"`
+	Receiver string   `xml:"receiver"`
+}
+
+type ProfitSharingReceiverResponse struct {
+	Response
+	MchId    string                `xml:"mch_id"`
+	AppId    string                `xml:"appid"`
+	Receiver ProfitSharingReceiver `xml:"receiver"`
+}
+
+var _ responsible = (*ProfitSharingReceiverResponse)(nil)
+
+func (r ProfitSharingReceiverResponse) AsError() error {
+	return ResponseError(r.Response)
+}
+
+// Share profit of a transaction to receivers once, the rest of the amount is
+// unfrozen to merchant. Need to set certificate (client.SetCertificate) first.
+// Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_1&index=1
+func (client *Client) ProfitSharing(ctx context.Context, req ProfitSharingRequest) (*ProfitSharingResponse, error) {
+	var res ProfitSharingResponse
+	if err := client.postXml(ctx, profitSharingUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Share profit of a transaction to receivers, can be called multiple times,
+// use FinishProfitSharing to unfreeze the rest of the amount. Need to set
+// certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_6&index=2
+func (client *Client) MultiProfitSharing(ctx context.Context, req ProfitSharingRequest) (*ProfitSharingResponse, error) {
+	var res ProfitSharingResponse
+	if err := client.postXml(ctx, multiProfitSharingUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ProfitSharingRequest is used in ProfitSharing() and MultiProfitSharing()
+// functions.
+type ProfitSharingRequest struct {
+	AppId         string               // required
+	TransactionId string               // required
+	OutOrderNo    string               // required
+	Receivers     ProfitSharingAmounts // required, max 50 receivers
+}
+
+var _ requestable = (*ProfitSharingRequest)(nil)
+
+func (r ProfitSharingRequest) toXml(client *Client) requestXml {
+	req := profitSharingRequestXml{}
+	req.MchId = client.MchId
+	req.SubAppId = client.SubAppId
+	req.SubMchId = client.SubMchId
+	req.AppId = r.AppId
+	req.NonceStr = randomStr(32)
+	req.SignType = "HMAC-SHA256" // only HMAC-SHA256 is supported
+	req.TransactionId = r.TransactionId
+	req.OutOrderNo = r.OutOrderNo
+	req.Receivers = r.Receivers.String()
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type profitSharingRequestXml struct {
+	XMLName       xml.Name `xml:"xml"`
+	MchId         string   `xml:"mch_id"`
+	SubAppId      string   `xml:"sub_appid,omitempty"`
+	SubMchId      string   `xml:"sub_mch_id,omitempty"`
+	AppId         string   `xml:"appid"`
+	NonceStr      string   `xml:"nonce_str"`
+	Sign          string   `xml:"sign"`
+	SignType      string   `xml:"sign_type"`
+	TransactionId string   `xml:"transaction_id"`
+	OutOrderNo    string   `xml:"out_order_no"`
+	Receivers     string   `xml:"receivers"`
+}
+
+type ProfitSharingResponse struct {
+	Response
+	MchId         string `xml:"mch_id"`
+	AppId         string `xml:"appid"`
+	TransactionId string `xml:"transaction_id"`
+	OutOrderNo    string `xml:"out_order_no"`
+	OrderId       string `xml:"order_id"`
+}
+
+var _ responsible = (*ProfitSharingResponse)(nil)
+
+func (r ProfitSharingResponse) AsError() error {
+	return ResponseError(r.Response)
+}
+
+// Query result of ProfitSharing or MultiProfitSharing. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_2&index=3
+func (client *Client) QueryProfitSharing(ctx context.Context, req QueryProfitSharingRequest) (*QueryProfitSharingResponse, error) {
+	var res QueryProfitSharingResponse
+	if err := client.postXml(ctx, queryProfitSharingUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// QueryProfitSharingRequest is used in QueryProfitSharing() function.
+type QueryProfitSharingRequest struct {
+	TransactionId string // required
+	OutOrderNo    string // required
+}
+
+var _ requestable = (*QueryProfitSharingRequest)(nil)
+
+func (r QueryProfitSharingRequest) toXml(client *Client) requestXml {
+	req := queryProfitSharingRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	req.SignType = "HMAC-SHA256" // only HMAC-SHA256 is supported
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type queryProfitSharingRequestXml struct {
+	XMLName       xml.Name `xml:"xml"`
+	MchId         string   `xml:"mch_id"`
+	SubMchId      string   `xml:"sub_mch_id,omitempty"`
+	TransactionId string   `xml:"transaction_id"`
+	OutOrderNo    string   `xml:"out_order_no"`
+	NonceStr      string   `xml:"nonce_str"`
+	Sign          string   `xml:"sign"`
+	SignType      string   `xml:"sign_type"`
+}
+
+type QueryProfitSharingResponse struct {
+	Response
+	MchId         string               `xml:"mch_id"`
+	TransactionId string               `xml:"transaction_id"`
+	OutOrderNo    string               `xml:"out_order_no"`
+	OrderId       string               `xml:"order_id"`
+	Status        string               `xml:"status"` // ACCEPTED, PROCESSING, FINISHED or CLOSED
+	CloseReason   string               `xml:"close_reason,omitempty"`
+	Receivers     ProfitSharingAmounts `xml:"receivers"`
+	Amount        int                  `xml:"amount"` // only available in FinishProfitSharing records
+	Description   string               `xml:"description"`
+}
+
+var _ responsible = (*QueryProfitSharingResponse)(nil)
+
+func (r QueryProfitSharingResponse) AsError() error {
+	return ResponseError(r.Response)
+}
+
+// Unfreeze the rest of the amount of a transaction after MultiProfitSharing.
+// Need to set certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_5&index=6
+func (client *Client) FinishProfitSharing(ctx context.Context, req FinishProfitSharingRequest) (*ProfitSharingResponse, error) {
+	var res ProfitSharingResponse
+	if err := client.postXml(ctx, finishProfitSharingUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// FinishProfitSharingRequest is used in FinishProfitSharing() function.
+type FinishProfitSharingRequest struct {
+	AppId         string // required
+	TransactionId string // required
+	OutOrderNo    string // required
+	Description   string // required
+}
+
+var _ requestable = (*FinishProfitSharingRequest)(nil)
+
+func (r FinishProfitSharingRequest) toXml(client *Client) requestXml {
+	req := finishProfitSharingRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	req.SignType = "HMAC-SHA256" // only HMAC-SHA256 is supported
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type finishProfitSharingRequestXml struct {
+	XMLName       xml.Name `xml:"xml"`
+	MchId         string   `xml:"mch_id"`
+	SubMchId      string   `xml:"sub_mch_id,omitempty"`
+	AppId         string   `xml:"appid"`
+	NonceStr      string   `xml:"nonce_str"`
+	Sign          string   `xml:"sign"`
+	SignType      string   `xml:"sign_type"`
+	TransactionId string   `xml:"transaction_id"`
+	OutOrderNo    string   `xml:"out_order_no"`
+	Amount        int      `xml:"amount"` // must be 0
+	Description   string   `xml:"description"`
+}
+
+// Return (回退) shared profit from a merchant receiver. Need to set
+// certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_7&index=7
+func (client *Client) ReturnProfitSharing(ctx context.Context, req ReturnProfitSharingRequest) (*ProfitSharingReturnResponse, error) {
+	var res ProfitSharingReturnResponse
+	if err := client.postXml(ctx, returnProfitSharingUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ReturnProfitSharingRequest is used in ReturnProfitSharing() function.
+type ReturnProfitSharingRequest struct {
+	AppId             string // required
+	OrderId           string // either OrderId or OutOrderNo is required
+	OutOrderNo        string
+	OutReturnNo       string // required
+	ReturnAccountType string // optional, MERCHANT_ID (default)
+	ReturnAccount     string // required, merchant id of the receiver
+	ReturnAmount      int    // required, in cents
+	Description       string // required
+}
+
+var _ requestable = (*ReturnProfitSharingRequest)(nil)
+
+func (r ReturnProfitSharingRequest) toXml(client *Client) requestXml {
+	req := returnProfitSharingRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	req.SignType = "HMAC-SHA256" // only HMAC-SHA256 is supported
+	if req.ReturnAccountType == "" {
+		req.ReturnAccountType = ProfitSharingReceiverMerchant
+	}
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type returnProfitSharingRequestXml struct {
+	XMLName           xml.Name `xml:"xml"`
+	MchId             string   `xml:"mch_id"`
+	SubMchId          string   `xml:"sub_mch_id,omitempty"`
+	AppId             string   `xml:"appid"`
+	NonceStr          string   `xml:"nonce_str"`
+	Sign              string   `xml:"sign"`
+	SignType          string   `xml:"sign_type"`
+	OrderId           string   `xml:"order_id,omitempty"`
+	OutOrderNo        string   `xml:"out_order_no,omitempty"`
+	OutReturnNo       string   `xml:"out_return_no"`
+	ReturnAccountType string   `xml:"return_account_type"`
+	ReturnAccount     string   `xml:"return_account"`
+	ReturnAmount      int      `xml:"return_amount"`
+	Description       string   `xml:"description"`
+}
+
+// Query result of ReturnProfitSharing. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_8&index=8
+func (client *Client) QueryProfitSharingReturn(ctx context.Context, req QueryProfitSharingReturnRequest) (*ProfitSharingReturnResponse, error) {
+	var res ProfitSharingReturnResponse
+	if err := client.postXml(ctx, queryProfitSharingReturnUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// QueryProfitSharingReturnRequest is used in QueryProfitSharingReturn()
+// function.
+type QueryProfitSharingReturnRequest struct {
+	AppId       string // required
+	OrderId     string // either OrderId or OutOrderNo is required
+	OutOrderNo  string
+	OutReturnNo string // required
+}
+
+var _ requestable = (*QueryProfitSharingReturnRequest)(nil)
+
+func (r QueryProfitSharingReturnRequest) toXml(client *Client) requestXml {
+	req := queryProfitSharingReturnRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.SubMchId = client.SubMchId
+	req.NonceStr = randomStr(32)
+	req.SignType = "HMAC-SHA256" // only HMAC-SHA256 is supported
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type queryProfitSharingReturnRequestXml struct {
+	XMLName     xml.Name `xml:"xml"`
+	MchId       string   `xml:"mch_id"`
+	SubMchId    string   `xml:"sub_mch_id,omitempty"`
+	AppId       string   `xml:"appid"`
+	NonceStr    string   `xml:"nonce_str"`
+	Sign        string   `xml:"sign"`
+	SignType    string   `xml:"sign_type"`
+	OrderId     string   `xml:"order_id,omitempty"`
+	OutOrderNo  string   `xml:"out_order_no,omitempty"`
+	OutReturnNo string   `xml:"out_return_no"`
+}
+
+type ProfitSharingReturnResponse struct {
+	Response
+	MchId             string `xml:"mch_id"`
+	AppId             string `xml:"appid"`
+	OrderId           string `xml:"order_id"`
+	OutOrderNo        string `xml:"out_order_no"`
+	OutReturnNo       string `xml:"out_return_no"`
+	ReturnNo          string `xml:"return_no"`
+	ReturnAccountType string `xml:"return_account_type"`
+	ReturnAccount     string `xml:"return_account"`
+	ReturnAmount      int    `xml:"return_amount"`
+	Description       string `xml:"description"`
+	Result            string `xml:"result"` // PROCESSING, SUCCESS or FAILED
+	FailReason        string `xml:"fail_reason,omitempty"`
+	FinishTime        string `xml:"finish_time,omitempty"` // UTC+8 time format: 20060102150405
+}
+
+var _ responsible = (*ProfitSharingReturnResponse)(nil)
+
+func (r ProfitSharingReturnResponse) AsError() error {
+	return ResponseError(r.Response)
+}
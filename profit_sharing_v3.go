@@ -0,0 +1,347 @@
+package wxpayslim
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+const (
+	v3ProfitSharingUrl         = prefix + "/v3/profitsharing/orders"
+	v3ProfitSharingUnfreezeUrl = prefix + "/v3/profitsharing/orders/unfreeze"
+	v3ProfitSharingReturnUrl   = prefix + "/v3/profitsharing/return-orders"
+	v3ProfitSharingAmountUrl   = prefix + "/v3/profitsharing/transactions"
+	v3ProfitSharingAddUrl      = prefix + "/v3/profitsharing/receivers/add"
+	v3ProfitSharingDeleteUrl   = prefix + "/v3/profitsharing/receivers/delete"
+)
+
+// Share profit of a transaction to receivers. Set UnfreezeUnsplit to true to
+// unfreeze the rest of the amount, otherwise use UnfreezeProfitSharingV3 after
+// all sharing is done. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/orders/create-order.html
+func (client *Client) ProfitSharingV3(ctx context.Context, req V3ProfitSharingRequest) (*V3ProfitSharingResponse, error) {
+	var res V3ProfitSharingResponse
+	if err := client.postJson(ctx, v3ProfitSharingUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3ProfitSharingRequest is used in ProfitSharingV3() function.
+type V3ProfitSharingRequest struct {
+	AppId           string                    // required
+	TransactionId   string                    // required
+	OutOrderNo      string                    // required
+	Receivers       []V3ProfitSharingReceiver // required, max 50 receivers
+	UnfreezeUnsplit bool                      // required
+}
+
+type V3ProfitSharingReceiver struct {
+	Type        string `json:"type"`                            // required, see ProfitSharingReceiver* constants
+	Account     string `json:"account"`                         // required, merchant id or openid
+	Name        string `json:"name,omitempty" sensitive:"true"` // optional, encrypted with platform certificate automatically
+	Amount      int    `json:"amount"`                          // required, in cents
+	Description string `json:"description"`                     // required
+}
+
+var _ jsonRequestable = (*V3ProfitSharingRequest)(nil)
+
+func (r V3ProfitSharingRequest) toJson(client *Client) requestJson {
+	req := profitSharingRequestJson{}
+	copyFields(r, &req)
+	req.SubMchId = client.SubMchId
+	req.SubAppId = client.SubAppId
+	return req
+}
+
+type profitSharingRequestJson struct {
+	SubMchId        string                    `json:"sub_mchid,omitempty"`
+	AppId           string                    `json:"appid"`
+	SubAppId        string                    `json:"sub_appid,omitempty"`
+	TransactionId   string                    `json:"transaction_id"`
+	OutOrderNo      string                    `json:"out_order_no"`
+	Receivers       []V3ProfitSharingReceiver `json:"receivers"`
+	UnfreezeUnsplit bool                      `json:"unfreeze_unsplit"`
+}
+
+type V3ProfitSharingResponse struct {
+	JsonResponse
+	TransactionId string                          `json:"transaction_id"`
+	OutOrderNo    string                          `json:"out_order_no"`
+	OrderId       string                          `json:"order_id"`
+	State         string                          `json:"state"` // PROCESSING or FINISHED
+	Receivers     []V3ProfitSharingReceiverResult `json:"receivers"`
+}
+
+type V3ProfitSharingReceiverResult struct {
+	Type        string     `json:"type"`
+	Account     string     `json:"account"`
+	Amount      int        `json:"amount"`
+	Description string     `json:"description"`
+	Result      string     `json:"result"` // PENDING, SUCCESS or CLOSED
+	FailReason  string     `json:"fail_reason,omitempty"`
+	DetailId    string     `json:"detail_id"`
+	CreateTime  *time.Time `json:"create_time,omitempty"`
+	FinishTime  *time.Time `json:"finish_time,omitempty"`
+}
+
+var _ responsible = (*V3ProfitSharingResponse)(nil)
+
+func (r V3ProfitSharingResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// Query result of ProfitSharingV3 or UnfreezeProfitSharingV3. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/orders/query-order.html
+func (client *Client) QueryProfitSharingV3(ctx context.Context, req V3QueryProfitSharingRequest) (*V3ProfitSharingResponse, error) {
+	query := url.Values{}
+	query.Set("transaction_id", req.TransactionId)
+	if client.SubMchId != "" {
+		query.Set("sub_mchid", client.SubMchId)
+	}
+	var res V3ProfitSharingResponse
+	reqUrl := v3ProfitSharingUrl + "/" + url.PathEscape(req.OutOrderNo) + "?" + query.Encode()
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryProfitSharingRequest is used in QueryProfitSharingV3() function.
+type V3QueryProfitSharingRequest struct {
+	TransactionId string // required
+	OutOrderNo    string // required
+}
+
+// Unfreeze the rest of the amount of a transaction. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/orders/unfreeze-order.html
+func (client *Client) UnfreezeProfitSharingV3(ctx context.Context, req V3UnfreezeProfitSharingRequest) (*V3ProfitSharingResponse, error) {
+	var res V3ProfitSharingResponse
+	if err := client.postJson(ctx, v3ProfitSharingUnfreezeUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3UnfreezeProfitSharingRequest is used in UnfreezeProfitSharingV3()
+// function.
+type V3UnfreezeProfitSharingRequest struct {
+	TransactionId string // required
+	OutOrderNo    string // required
+	Description   string // required
+}
+
+var _ jsonRequestable = (*V3UnfreezeProfitSharingRequest)(nil)
+
+func (r V3UnfreezeProfitSharingRequest) toJson(client *Client) requestJson {
+	req := unfreezeProfitSharingRequestJson{}
+	copyFields(r, &req)
+	req.SubMchId = client.SubMchId
+	return req
+}
+
+type unfreezeProfitSharingRequestJson struct {
+	SubMchId      string `json:"sub_mchid,omitempty"`
+	TransactionId string `json:"transaction_id"`
+	OutOrderNo    string `json:"out_order_no"`
+	Description   string `json:"description"`
+}
+
+// Return (回退) shared profit from a merchant receiver. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/return-orders/create-return-order.html
+func (client *Client) ReturnProfitSharingV3(ctx context.Context, req V3ReturnProfitSharingRequest) (*V3ProfitSharingReturnResponse, error) {
+	var res V3ProfitSharingReturnResponse
+	if err := client.postJson(ctx, v3ProfitSharingReturnUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3ReturnProfitSharingRequest is used in ReturnProfitSharingV3() function.
+type V3ReturnProfitSharingRequest struct {
+	OrderId     string // either OrderId or OutOrderNo is required
+	OutOrderNo  string
+	OutReturnNo string // required
+	ReturnMchId string // required, merchant id of the receiver
+	Amount      int    // required, in cents
+	Description string // required
+}
+
+var _ jsonRequestable = (*V3ReturnProfitSharingRequest)(nil)
+
+func (r V3ReturnProfitSharingRequest) toJson(client *Client) requestJson {
+	req := returnProfitSharingRequestJson{}
+	copyFields(r, &req)
+	req.SubMchId = client.SubMchId
+	return req
+}
+
+type returnProfitSharingRequestJson struct {
+	SubMchId    string `json:"sub_mchid,omitempty"`
+	OrderId     string `json:"order_id,omitempty"`
+	OutOrderNo  string `json:"out_order_no,omitempty"`
+	OutReturnNo string `json:"out_return_no"`
+	ReturnMchId string `json:"return_mchid"`
+	Amount      int    `json:"amount"`
+	Description string `json:"description"`
+}
+
+// Query result of ReturnProfitSharingV3. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/return-orders/query-return-order.html
+func (client *Client) QueryProfitSharingReturnV3(ctx context.Context, req V3QueryProfitSharingReturnRequest) (*V3ProfitSharingReturnResponse, error) {
+	query := url.Values{}
+	query.Set("out_order_no", req.OutOrderNo)
+	if client.SubMchId != "" {
+		query.Set("sub_mchid", client.SubMchId)
+	}
+	var res V3ProfitSharingReturnResponse
+	reqUrl := v3ProfitSharingReturnUrl + "/" + url.PathEscape(req.OutReturnNo) + "?" + query.Encode()
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryProfitSharingReturnRequest is used in QueryProfitSharingReturnV3()
+// function.
+type V3QueryProfitSharingReturnRequest struct {
+	OutOrderNo  string // required
+	OutReturnNo string // required
+}
+
+type V3ProfitSharingReturnResponse struct {
+	JsonResponse
+	OrderId     string     `json:"order_id"`
+	OutOrderNo  string     `json:"out_order_no"`
+	OutReturnNo string     `json:"out_return_no"`
+	ReturnId    string     `json:"return_id"`
+	ReturnMchId string     `json:"return_mchid"`
+	Amount      int        `json:"amount"`
+	Description string     `json:"description"`
+	Result      string     `json:"result"` // PROCESSING, SUCCESS or FAILED
+	FailReason  string     `json:"fail_reason,omitempty"`
+	CreateTime  *time.Time `json:"create_time,omitempty"`
+	FinishTime  *time.Time `json:"finish_time,omitempty"`
+}
+
+var _ responsible = (*V3ProfitSharingReturnResponse)(nil)
+
+func (r V3ProfitSharingReturnResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// Query the amount of a transaction which is not shared yet. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/transactions/query-order-amount.html
+func (client *Client) QueryProfitSharingAmountV3(ctx context.Context, transactionId string) (*V3ProfitSharingAmountResponse, error) {
+	var res V3ProfitSharingAmountResponse
+	reqUrl := v3ProfitSharingAmountUrl + "/" + url.PathEscape(transactionId) + "/amounts"
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+type V3ProfitSharingAmountResponse struct {
+	JsonResponse
+	TransactionId string `json:"transaction_id"`
+	UnsplitAmount int    `json:"unsplit_amount"`
+}
+
+var _ responsible = (*V3ProfitSharingAmountResponse)(nil)
+
+func (r V3ProfitSharingAmountResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// Add receiver before sharing profit to it. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/receivers/add-receiver.html
+func (client *Client) AddProfitSharingReceiverV3(ctx context.Context, req V3AddProfitSharingReceiverRequest) (*V3ProfitSharingReceiverResponse, error) {
+	var res V3ProfitSharingReceiverResponse
+	if err := client.postJson(ctx, v3ProfitSharingAddUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3AddProfitSharingReceiverRequest is used in AddProfitSharingReceiverV3()
+// function.
+type V3AddProfitSharingReceiverRequest struct {
+	AppId          string // required
+	Type           string // required, see ProfitSharingReceiver* constants
+	Account        string // required, merchant id or openid
+	Name           string // required if Type is MERCHANT_ID, encrypted with platform certificate automatically
+	RelationType   string // required, like SERVICE_PROVIDER, STORE, STAFF or CUSTOM
+	CustomRelation string // required if RelationType is CUSTOM
+}
+
+var _ jsonRequestable = (*V3AddProfitSharingReceiverRequest)(nil)
+
+func (r V3AddProfitSharingReceiverRequest) toJson(client *Client) requestJson {
+	req := addProfitSharingReceiverRequestJson{}
+	copyFields(r, &req)
+	req.SubMchId = client.SubMchId
+	req.SubAppId = client.SubAppId
+	return req
+}
+
+type addProfitSharingReceiverRequestJson struct {
+	SubMchId       string `json:"sub_mchid,omitempty"`
+	AppId          string `json:"appid"`
+	SubAppId       string `json:"sub_appid,omitempty"`
+	Type           string `json:"type"`
+	Account        string `json:"account"`
+	Name           string `json:"name,omitempty" sensitive:"true"`
+	RelationType   string `json:"relation_type"`
+	CustomRelation string `json:"custom_relation,omitempty"`
+}
+
+// Remove receiver added by AddProfitSharingReceiverV3. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/profit-sharing/receivers/delete-receiver.html
+func (client *Client) RemoveProfitSharingReceiverV3(ctx context.Context, req V3RemoveProfitSharingReceiverRequest) (*V3ProfitSharingReceiverResponse, error) {
+	var res V3ProfitSharingReceiverResponse
+	if err := client.postJson(ctx, v3ProfitSharingDeleteUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3RemoveProfitSharingReceiverRequest is used in
+// RemoveProfitSharingReceiverV3() function.
+type V3RemoveProfitSharingReceiverRequest struct {
+	AppId   string // required
+	Type    string // required, see ProfitSharingReceiver* constants
+	Account string // required, merchant id or openid
+}
+
+var _ jsonRequestable = (*V3RemoveProfitSharingReceiverRequest)(nil)
+
+func (r V3RemoveProfitSharingReceiverRequest) toJson(client *Client) requestJson {
+	req := removeProfitSharingReceiverRequestJson{}
+	copyFields(r, &req)
+	req.SubMchId = client.SubMchId
+	req.SubAppId = client.SubAppId
+	return req
+}
+
+type removeProfitSharingReceiverRequestJson struct {
+	SubMchId string `json:"sub_mchid,omitempty"`
+	AppId    string `json:"appid"`
+	SubAppId string `json:"sub_appid,omitempty"`
+	Type     string `json:"type"`
+	Account  string `json:"account"`
+}
+
+type V3ProfitSharingReceiverResponse struct {
+	JsonResponse
+	Type           string `json:"type"`
+	Account        string `json:"account"`
+	Name           string `json:"name,omitempty"` // encrypted, use DecryptSensitive() to decrypt
+	RelationType   string `json:"relation_type,omitempty"`
+	CustomRelation string `json:"custom_relation,omitempty"`
+}
+
+var _ responsible = (*V3ProfitSharingReceiverResponse)(nil)
+
+func (r V3ProfitSharingReceiverResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
@@ -0,0 +1,177 @@
+package wxpayslim
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	v3TransferBatchReceiptUrl  = prefix + "/v3/transfer/bill-receipt"
+	v3TransferDetailReceiptUrl = prefix + "/v3/transfer-detail/electronic-receipts"
+)
+
+// ApplyTransferBatchReceiptV3 applies electronic receipt of a transfer batch
+// created by TransferV3. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/batch-transfer-to-balance/electronic-signature/create-electronic-signature.html
+func (client *Client) ApplyTransferBatchReceiptV3(ctx context.Context, req V3TransferBatchReceiptRequest) (*V3TransferReceiptResponse, error) {
+	var res V3TransferReceiptResponse
+	if err := client.postJson(ctx, v3TransferBatchReceiptUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// QueryTransferBatchReceiptV3 gets electronic receipt of a transfer batch,
+// DownloadUrl is available when SignatureStatus is FINISHED. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/batch-transfer-to-balance/electronic-signature/get-electronic-signature-by-out-no.html
+func (client *Client) QueryTransferBatchReceiptV3(ctx context.Context, req V3TransferBatchReceiptRequest) (*V3TransferReceiptResponse, error) {
+	var res V3TransferReceiptResponse
+	if err := client.getJson(ctx, v3TransferBatchReceiptUrl+"/"+url.PathEscape(req.OutBatchNo), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3TransferBatchReceiptRequest is used in ApplyTransferBatchReceiptV3() and
+// QueryTransferBatchReceiptV3() functions.
+type V3TransferBatchReceiptRequest struct {
+	OutBatchNo string // required
+}
+
+var _ jsonRequestable = (*V3TransferBatchReceiptRequest)(nil)
+
+func (r V3TransferBatchReceiptRequest) toJson(client *Client) requestJson {
+	return transferBatchReceiptRequestJson{
+		OutBatchNo: r.OutBatchNo,
+	}
+}
+
+type transferBatchReceiptRequestJson struct {
+	OutBatchNo string `json:"out_batch_no"`
+}
+
+// ApplyTransferDetailReceiptV3 applies electronic receipt of a transfer
+// detail, made by TransferV3 or Transfer. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/batch-transfer-to-balance/electronic-receipt-api/create-electronic-receipt.html
+func (client *Client) ApplyTransferDetailReceiptV3(ctx context.Context, req V3TransferDetailReceiptRequest) (*V3TransferReceiptResponse, error) {
+	var res V3TransferReceiptResponse
+	if err := client.postJson(ctx, v3TransferDetailReceiptUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// QueryTransferDetailReceiptV3 gets electronic receipt of a transfer detail,
+// DownloadUrl is available when SignatureStatus is FINISHED. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/batch-transfer-to-balance/electronic-receipt-api/query-electronic-receipt.html
+func (client *Client) QueryTransferDetailReceiptV3(ctx context.Context, req V3TransferDetailReceiptRequest) (*V3TransferReceiptResponse, error) {
+	query := url.Values{}
+	query.Set("accept_type", req.AcceptType)
+	if req.OutBatchNo != "" {
+		query.Set("out_batch_no", req.OutBatchNo)
+	}
+	query.Set("out_detail_no", req.OutDetailNo)
+	var res V3TransferReceiptResponse
+	if err := client.getJson(ctx, v3TransferDetailReceiptUrl+"?"+query.Encode(), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3TransferDetailReceiptRequest is used in ApplyTransferDetailReceiptV3()
+// and QueryTransferDetailReceiptV3() functions.
+type V3TransferDetailReceiptRequest struct {
+	AcceptType  string // required, BATCH_TRANSFER (TransferV3), TRANSFER_TO_POCKET (Transfer) or TRANSFER_TO_BANK
+	OutBatchNo  string // required if AcceptType is BATCH_TRANSFER
+	OutDetailNo string // required, OutDetailNo of TransferV3 or PartnerTradeNo of Transfer
+}
+
+var _ jsonRequestable = (*V3TransferDetailReceiptRequest)(nil)
+
+func (r V3TransferDetailReceiptRequest) toJson(client *Client) requestJson {
+	return transferDetailReceiptRequestJson{
+		AcceptType:  r.AcceptType,
+		OutBatchNo:  r.OutBatchNo,
+		OutDetailNo: r.OutDetailNo,
+	}
+}
+
+type transferDetailReceiptRequestJson struct {
+	AcceptType  string `json:"accept_type"`
+	OutBatchNo  string `json:"out_batch_no,omitempty"`
+	OutDetailNo string `json:"out_detail_no"`
+}
+
+type V3TransferReceiptResponse struct {
+	JsonResponse
+	V3TransferReceipt
+}
+
+var _ responsible = (*V3TransferReceiptResponse)(nil)
+
+func (r V3TransferReceiptResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+type V3TransferReceipt struct {
+	AcceptType      string     `json:"accept_type,omitempty"`
+	OutBatchNo      string     `json:"out_batch_no,omitempty"`
+	OutDetailNo     string     `json:"out_detail_no,omitempty"`
+	SignatureNo     string     `json:"signature_no"`
+	SignatureStatus string     `json:"signature_status"` // ACCEPTED or FINISHED
+	HashType        string     `json:"hash_type,omitempty"`
+	HashValue       string     `json:"hash_value,omitempty"`
+	DownloadUrl     string     `json:"download_url,omitempty"`
+	CreateTime      *time.Time `json:"create_time,omitempty"`
+	UpdateTime      *time.Time `json:"update_time,omitempty"`
+}
+
+// DownloadReceiptV3 downloads the receipt (PDF file) of a finished receipt and
+// checks its hash.
+func (client *Client) DownloadReceiptV3(ctx context.Context, receipt V3TransferReceipt) (io.Reader, error) {
+	if receipt.DownloadUrl == "" {
+		return nil, errors.New("receipt is not ready, signature status: " + receipt.SignatureStatus)
+	}
+	// not sendJson, the file is not JSON
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, receipt.DownloadUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := client.generateAuthorization(http.MethodGet, receipt.DownloadUrl, "")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", auth)
+	b, resp, err := client.post(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		var res V3TransferReceiptResponse
+		var resErr JsonResponseError
+		if err := decodeJsonResponse(b, resp.StatusCode, &res); errors.As(err, &resErr) {
+			return nil, err
+		}
+		return nil, JsonResponseError{
+			Code:    "UNKNOWN",
+			Message: "未知错误，状态：" + strconv.Itoa(resp.StatusCode),
+		}
+	}
+	if !strings.EqualFold(receipt.HashType, "SHA256") {
+		return nil, errors.New("unsupported hash type: " + receipt.HashType)
+	}
+	h := sha256.Sum256(b)
+	if !strings.EqualFold(hex.EncodeToString(h[:]), receipt.HashValue) {
+		return nil, errors.New("hash of receipt does not match")
+	}
+	return bytes.NewReader(b), nil
+}
@@ -0,0 +1,239 @@
+package wxpayslim
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+const (
+	sendRedPackUrl      = prefix + "/mmpaymkttransfers/sendredpack"
+	sendGroupRedPackUrl = prefix + "/mmpaymkttransfers/sendgroupredpack"
+	redPackQueryUrl     = prefix + "/mmpaymkttransfers/gethbinfo"
+)
+
+// Scenes of red packets, required if amount is lower than 1 yuan or greater
+// than 200 yuan.
+const (
+	RedPackSceneProductPromotion  = "PRODUCT_1" // 商品促销
+	RedPackSceneLottery           = "PRODUCT_2" // 抽奖
+	RedPackSceneVirtualPrize      = "PRODUCT_3" // 虚拟物品兑奖
+	RedPackSceneEnterpriseWelfare = "PRODUCT_4" // 企业内部福利
+	RedPackSceneChannelRebate     = "PRODUCT_5" // 渠道分润
+	RedPackSceneInsurance         = "PRODUCT_6" // 保险回馈
+	RedPackSceneLotteryGame       = "PRODUCT_7" // 彩票派奖
+	RedPackSceneTaxLottery        = "PRODUCT_8" // 税务刮奖
+)
+
+// Send cash red packet to user. Need to set certificate
+// (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/tools/cash_coupon.php?chapter=13_4&index=3
+func (client *Client) SendRedPack(ctx context.Context, req RedPackRequest) (*RedPackResponse, error) {
+	var res RedPackResponse
+	if err := client.postXml(ctx, sendRedPackUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RedPackRequest is used in SendRedPack() function.
+type RedPackRequest struct {
+	MchBillNo   string // required
+	WxAppId     string // required
+	SendName    string // required, name of the sender
+	ReOpenId    string // required, openid of the receiver
+	TotalAmount int    // required, in cents
+	Wishing     string // required
+	ClientIp    string // required
+	ActName     string // required
+	Remark      string // required
+	SceneId     string // optional, see RedPackScene* constants
+	RiskInfo    string // optional, url encoded, like posttime=xx&clientversion=xx
+}
+
+var _ requestable = (*RedPackRequest)(nil)
+var _ unsignedResponder = (*RedPackRequest)(nil)
+
+func (r RedPackRequest) unsignedResponse() {}
+
+func (r RedPackRequest) toXml(client *Client) requestXml {
+	req := redPackRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.NonceStr = randomStr(32)
+	req.TotalNum = 1
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type redPackRequestXml struct {
+	XMLName     xml.Name `xml:"xml"`
+	NonceStr    string   `xml:"nonce_str"`
+	Sign        string   `xml:"sign"`
+	MchBillNo   string   `xml:"mch_billno"`
+	MchId       string   `xml:"mch_id"`
+	WxAppId     string   `xml:"wxappid"`
+	SendName    string   `xml:"send_name"`
+	ReOpenId    string   `xml:"re_openid"`
+	TotalAmount int      `xml:"total_amount"`
+	TotalNum    int      `xml:"total_num"`
+	Wishing     string   `xml:"wishing"`
+	ClientIp    string   `xml:"client_ip"`
+	ActName     string   `xml:"act_name"`
+	Remark      string   `xml:"remark"`
+	SceneId     string   `xml:"scene_id,omitempty"`
+	RiskInfo    string   `xml:"risk_info,omitempty"`
+}
+
+type RedPackResponse struct {
+	Response
+	MchBillNo   string `xml:"mch_billno"`
+	MchId       string `xml:"mch_id"`
+	WxAppId     string `xml:"wxappid"`
+	ReOpenId    string `xml:"re_openid"`
+	TotalAmount int    `xml:"total_amount"`
+	SendListId  string `xml:"send_listid"`
+}
+
+var _ responsible = (*RedPackResponse)(nil)
+
+func (r RedPackResponse) AsError() error {
+	return ResponseError(r.Response)
+}
+
+// Send group red packet (裂变红包) to user, which can be shared with friends.
+// Need to set certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/tools/cash_coupon.php?chapter=13_5&index=4
+func (client *Client) SendGroupRedPack(ctx context.Context, req GroupRedPackRequest) (*RedPackResponse, error) {
+	var res RedPackResponse
+	if err := client.postXml(ctx, sendGroupRedPackUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GroupRedPackRequest is used in SendGroupRedPack() function.
+type GroupRedPackRequest struct {
+	MchBillNo   string // required
+	WxAppId     string // required
+	SendName    string // required, name of the sender
+	ReOpenId    string // required, openid of the seed user
+	TotalAmount int    // required, in cents
+	TotalNum    int    // required, 3 to 20
+	AmtType     string // optional, ALL_RAND (default)
+	Wishing     string // required
+	ActName     string // required
+	Remark      string // required
+	SceneId     string // optional, see RedPackScene* constants
+	RiskInfo    string // optional, url encoded, like posttime=xx&clientversion=xx
+}
+
+var _ requestable = (*GroupRedPackRequest)(nil)
+var _ unsignedResponder = (*GroupRedPackRequest)(nil)
+
+func (r GroupRedPackRequest) unsignedResponse() {}
+
+func (r GroupRedPackRequest) toXml(client *Client) requestXml {
+	req := groupRedPackRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.NonceStr = randomStr(32)
+	if req.AmtType == "" {
+		req.AmtType = "ALL_RAND"
+	}
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type groupRedPackRequestXml struct {
+	XMLName     xml.Name `xml:"xml"`
+	NonceStr    string   `xml:"nonce_str"`
+	Sign        string   `xml:"sign"`
+	MchBillNo   string   `xml:"mch_billno"`
+	MchId       string   `xml:"mch_id"`
+	WxAppId     string   `xml:"wxappid"`
+	SendName    string   `xml:"send_name"`
+	ReOpenId    string   `xml:"re_openid"`
+	TotalAmount int      `xml:"total_amount"`
+	TotalNum    int      `xml:"total_num"`
+	AmtType     string   `xml:"amt_type"`
+	Wishing     string   `xml:"wishing"`
+	ActName     string   `xml:"act_name"`
+	Remark      string   `xml:"remark"`
+	SceneId     string   `xml:"scene_id,omitempty"`
+	RiskInfo    string   `xml:"risk_info,omitempty"`
+}
+
+// Query red packet sent by SendRedPack or SendGroupRedPack. Need to set
+// certificate (client.SetCertificate) first. Docs:
+// https://pay.weixin.qq.com/wiki/doc/api/tools/cash_coupon.php?chapter=13_6&index=5
+func (client *Client) RedPackQuery(ctx context.Context, req RedPackQueryRequest) (*RedPackQueryResponse, error) {
+	var res RedPackQueryResponse
+	if err := client.postXml(ctx, redPackQueryUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RedPackQueryRequest is used in RedPackQuery() function.
+type RedPackQueryRequest struct {
+	AppId     string // required
+	MchBillNo string // required
+}
+
+var _ requestable = (*RedPackQueryRequest)(nil)
+var _ unsignedResponder = (*RedPackQueryRequest)(nil)
+
+func (r RedPackQueryRequest) unsignedResponse() {}
+
+func (r RedPackQueryRequest) toXml(client *Client) requestXml {
+	req := redPackQueryRequestXml{}
+	copyFields(r, &req)
+	req.MchId = client.MchId
+	req.NonceStr = randomStr(32)
+	req.BillType = "MCHT"
+	req.Sign = client.generateSign(req)
+	return req
+}
+
+type redPackQueryRequestXml struct {
+	XMLName   xml.Name `xml:"xml"`
+	NonceStr  string   `xml:"nonce_str"`
+	Sign      string   `xml:"sign"`
+	MchBillNo string   `xml:"mch_billno"`
+	MchId     string   `xml:"mch_id"`
+	AppId     string   `xml:"appid"`
+	BillType  string   `xml:"bill_type"`
+}
+
+type RedPackQueryResponse struct {
+	Response
+	MchBillNo    string            `xml:"mch_billno"`
+	MchId        string            `xml:"mch_id"`
+	DetailId     string            `xml:"detail_id"`
+	Status       string            `xml:"status"`    // SENDING, SENT, FAILED, RECEIVED, RFUND_ING or REFUND
+	SendType     string            `xml:"send_type"` // API, UPLOAD or ACTIVITY
+	HbType       string            `xml:"hb_type"`   // GROUP or NORMAL
+	TotalNum     int               `xml:"total_num"`
+	TotalAmount  int               `xml:"total_amount"`
+	Reason       string            `xml:"reason,omitempty"`
+	SendTime     *Utc8Time         `xml:"send_time"`
+	RefundTime   *Utc8Time         `xml:"refund_time"`
+	RefundAmount int               `xml:"refund_amount"`
+	Wishing      string            `xml:"wishing"`
+	Remark       string            `xml:"remark"`
+	ActName      string            `xml:"act_name"`
+	HbList       []RedPackReceiver `xml:"hblist>hbinfo"`
+}
+
+// RedPackReceiver is the user who received (part of) the red packet.
+type RedPackReceiver struct {
+	OpenId  string    `xml:"openid"`
+	Amount  int       `xml:"amount"`
+	RcvTime *Utc8Time `xml:"rcv_time"`
+}
+
+var _ responsible = (*RedPackQueryResponse)(nil)
+
+func (r RedPackQueryResponse) AsError() error {
+	return ResponseError(r.Response)
+}
@@ -0,0 +1,199 @@
+package wxpayslim
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+const (
+	v3RefundUrl = prefix + "/v3/refund/domestic/refunds"
+)
+
+// Status of v3 refunds.
+const (
+	V3RefundStatusSuccess    = "SUCCESS"
+	V3RefundStatusClosed     = "CLOSED"
+	V3RefundStatusProcessing = "PROCESSING"
+	V3RefundStatusAbnormal   = "ABNORMAL" // use ApplyAbnormalRefundV3 to refund again
+)
+
+// RefundOrderV3 initiates refund. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/create.html
+func (client *Client) RefundOrderV3(ctx context.Context, req V3RefundOrderRequest) (*V3RefundOrderResponse, error) {
+	var res V3RefundOrderResponse
+	if err := client.postJson(ctx, v3RefundUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3RefundOrderRequest is used in RefundOrderV3() function.
+type V3RefundOrderRequest struct {
+	TransactionId string // either TransactionId or OutTradeNo is required
+	OutTradeNo    string
+	OutRefundNo   string                // required, max length is 64
+	Reason        string                // optional, max length is 80
+	NotifyUrl     string                // optional
+	FundsAccount  string                // optional, set to AVAILABLE to refund from available balance
+	Amount        V3RefundAmount        // required
+	GoodsDetail   []V3RefundGoodsDetail // optional
+}
+
+type V3RefundAmount struct {
+	Refund   int            `json:"refund"`         // in cents
+	From     []V3RefundFrom `json:"from,omitempty"` // optional, accounts to refund from
+	Total    int            `json:"total"`          // in cents, total amount of the order
+	Currency string         `json:"currency"`       // CNY
+}
+
+type V3RefundFrom struct {
+	Account string `json:"account"` // AVAILABLE or UNAVAILABLE
+	Amount  int    `json:"amount"`
+}
+
+type V3RefundGoodsDetail struct {
+	MerchantGoodsId  string `json:"merchant_goods_id"`
+	WechatpayGoodsId string `json:"wechatpay_goods_id,omitempty"`
+	GoodsName        string `json:"goods_name,omitempty"`
+	UnitPrice        int    `json:"unit_price"`
+	RefundAmount     int    `json:"refund_amount"`
+	RefundQuantity   int    `json:"refund_quantity"`
+}
+
+var _ jsonRequestable = (*V3RefundOrderRequest)(nil)
+
+func (r V3RefundOrderRequest) toJson(client *Client) requestJson {
+	req := refundOrderRequestJson{}
+	copyFields(r, &req)
+	req.SubMchId = client.SubMchId
+	if req.Amount.Currency == "" {
+		req.Amount.Currency = "CNY"
+	}
+	return req
+}
+
+type refundOrderRequestJson struct {
+	SubMchId      string                `json:"sub_mchid,omitempty"`
+	TransactionId string                `json:"transaction_id,omitempty"`
+	OutTradeNo    string                `json:"out_trade_no,omitempty"`
+	OutRefundNo   string                `json:"out_refund_no"`
+	Reason        string                `json:"reason,omitempty"`
+	NotifyUrl     string                `json:"notify_url,omitempty"`
+	FundsAccount  string                `json:"funds_account,omitempty"`
+	Amount        V3RefundAmount        `json:"amount"`
+	GoodsDetail   []V3RefundGoodsDetail `json:"goods_detail,omitempty"`
+}
+
+type V3RefundOrderResponse struct {
+	JsonResponse
+	V3Refund
+}
+
+var _ responsible = (*V3RefundOrderResponse)(nil)
+
+func (r V3RefundOrderResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// V3Refund is the refund order in v3 responses.
+type V3Refund struct {
+	RefundId            string     `json:"refund_id"`
+	OutRefundNo         string     `json:"out_refund_no"`
+	TransactionId       string     `json:"transaction_id"`
+	OutTradeNo          string     `json:"out_trade_no"`
+	Channel             string     `json:"channel"` // ORIGINAL, BALANCE, OTHER_BALANCE or OTHER_BANKCARD
+	UserReceivedAccount string     `json:"user_received_account"`
+	SuccessTime         *time.Time `json:"success_time,omitempty"`
+	CreateTime          time.Time  `json:"create_time"`
+	Status              string     `json:"status"` // SUCCESS, CLOSED, PROCESSING or ABNORMAL
+	FundsAccount        string     `json:"funds_account"`
+	Amount              struct {
+		Total            int            `json:"total"`
+		Refund           int            `json:"refund"`
+		From             []V3RefundFrom `json:"from,omitempty"`
+		PayerTotal       int            `json:"payer_total"`
+		PayerRefund      int            `json:"payer_refund"`
+		SettlementRefund int            `json:"settlement_refund"`
+		SettlementTotal  int            `json:"settlement_total"`
+		DiscountRefund   int            `json:"discount_refund"`
+		Currency         string         `json:"currency"`
+		RefundFee        int            `json:"refund_fee"`
+	} `json:"amount"`
+	PromotionDetail []struct {
+		PromotionId  string                `json:"promotion_id"`
+		Scope        string                `json:"scope"` // GLOBAL or SINGLE
+		Type         string                `json:"type"`  // COUPON or DISCOUNT
+		Amount       int                   `json:"amount"`
+		RefundAmount int                   `json:"refund_amount"`
+		GoodsDetail  []V3RefundGoodsDetail `json:"goods_detail,omitempty"`
+	} `json:"promotion_detail,omitempty"`
+}
+
+// Check if order is successfully refunded.
+func (r V3Refund) Refunded() bool {
+	return r.Status == V3RefundStatusSuccess
+}
+
+// QueryRefundOrderV3 gets information of a refund order by Refund No. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/query-by-out-refund-no.html
+func (client *Client) QueryRefundOrderV3(ctx context.Context, req V3QueryRefundOrderRequest) (*V3RefundOrderResponse, error) {
+	reqUrl := v3RefundUrl + "/" + url.PathEscape(req.OutRefundNo)
+	if client.SubMchId != "" {
+		reqUrl += "?sub_mchid=" + url.QueryEscape(client.SubMchId)
+	}
+	var res V3RefundOrderResponse
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryRefundOrderRequest is used in QueryRefundOrderV3() function.
+type V3QueryRefundOrderRequest struct {
+	OutRefundNo string // required
+}
+
+// ApplyAbnormalRefundV3 refunds again to a bank card if refund status is
+// ABNORMAL. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/create-abnormal-refund.html
+func (client *Client) ApplyAbnormalRefundV3(ctx context.Context, req V3ApplyAbnormalRefundRequest) (*V3RefundOrderResponse, error) {
+	var res V3RefundOrderResponse
+	reqUrl := v3RefundUrl + "/" + url.PathEscape(req.RefundId) + "/apply-abnormal-refund"
+	if err := client.postJson(ctx, reqUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3ApplyAbnormalRefundRequest is used in ApplyAbnormalRefundV3() function.
+type V3ApplyAbnormalRefundRequest struct {
+	RefundId    string // required
+	OutRefundNo string // required
+	Type        string // required, either USER_BANK_CARD or MERCHANT_BANK_CARD
+	BankType    string // required if Type is USER_BANK_CARD
+	BankAccount string // required if Type is USER_BANK_CARD, encrypted automatically
+	RealName    string // required if Type is USER_BANK_CARD, encrypted automatically
+}
+
+var _ jsonRequestable = (*V3ApplyAbnormalRefundRequest)(nil)
+
+func (r V3ApplyAbnormalRefundRequest) toJson(client *Client) requestJson {
+	req := applyAbnormalRefundRequestJson{}
+	req.SubMchId = client.SubMchId
+	req.OutRefundNo = r.OutRefundNo
+	req.Type = r.Type
+	req.BankType = r.BankType
+	req.BankAccount = r.BankAccount
+	req.RealName = r.RealName
+	return req
+}
+
+type applyAbnormalRefundRequestJson struct {
+	SubMchId    string `json:"sub_mchid,omitempty"`
+	OutRefundNo string `json:"out_refund_no"`
+	Type        string `json:"type"`
+	BankType    string `json:"bank_type,omitempty"`
+	BankAccount string `json:"bank_account,omitempty" sensitive:"true"`
+	RealName    string `json:"real_name,omitempty" sensitive:"true"`
+}
@@ -0,0 +1,139 @@
+package wxpayslim
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"reflect"
+)
+
+// DecryptSensitive decrypts sensitive field (like user_name) in v3 responses
+// with merchant private key. Need to set certificate (client.SetCertificate)
+// first.
+func (client *Client) DecryptSensitive(ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	pk, err := client.privateKey()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := rsa.DecryptOAEP(sha1.New(), cryptoRand.Reader, pk, data, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// encryptSensitiveFields returns a copy of JSON object with all non-empty
+// string fields tagged with `sensitive:"true"` encrypted with platform public
+// key or certificate, and serial of the key used. Serial is empty if nothing
+// is encrypted.
+func (client *Client) encryptSensitiveFields(ctx context.Context, object requestJson) (requestJson, string, error) {
+	var serial string
+	var key *rsa.PublicKey
+	encrypt := func(plaintext string) (string, error) {
+		if key == nil {
+			var err error
+			serial, key, err = client.encryptionKey(ctx)
+			if err != nil {
+				return "", err
+			}
+		}
+		ciphertext, err := rsa.EncryptOAEP(sha1.New(), cryptoRand.Reader, key, []byte(plaintext), nil)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(ciphertext), nil
+	}
+	rv, err := encryptSensitiveValue(reflect.ValueOf(object), encrypt)
+	if err != nil {
+		return nil, "", err
+	}
+	return rv.Interface(), serial, nil
+}
+
+// encryptSensitiveValue copies structs, pointers and slices in v, so the
+// original object is not modified.
+func encryptSensitiveValue(v reflect.Value, encrypt func(string) (string, error)) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := out.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+			if v.Type().Field(i).Tag.Get("sensitive") == "true" && field.Kind() == reflect.String {
+				if field.String() == "" {
+					continue
+				}
+				ciphertext, err := encrypt(field.String())
+				if err != nil {
+					return v, err
+				}
+				field.SetString(ciphertext)
+				continue
+			}
+			value, err := encryptSensitiveValue(v.Field(i), encrypt)
+			if err != nil {
+				return v, err
+			}
+			field.Set(value)
+		}
+		return out, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, nil
+		}
+		value, err := encryptSensitiveValue(v.Elem(), encrypt)
+		if err != nil {
+			return v, err
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(value)
+		return out, nil
+	case reflect.Slice:
+		if v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8 {
+			return v, nil
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := encryptSensitiveValue(v.Index(i), encrypt)
+			if err != nil {
+				return v, err
+			}
+			out.Index(i).Set(value)
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// encryptionKey returns platform public key if set, otherwise the latest
+// platform certificate, downloading certificates if there is none.
+func (client *Client) encryptionKey(ctx context.Context) (string, *rsa.PublicKey, error) {
+	if client.platformPublicKey != nil {
+		return client.platformPublicKeyId, client.platformPublicKey, nil
+	}
+	serial, cert := client.certificates().latest()
+	if cert == nil && client.APIv3Key != "" {
+		if err := client.UpdateCertificates(ctx); err != nil {
+			return "", nil, err
+		}
+		serial, cert = client.certificates().latest()
+	}
+	if cert == nil {
+		return "", nil, errors.New("no platform certificate to encrypt sensitive fields")
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return "", nil, errors.New("platform certificate is not RSA")
+	}
+	return serial, key, nil
+}
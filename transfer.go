@@ -3,6 +3,8 @@ package wxpayslim
 import (
 	"context"
 	"encoding/xml"
+	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -13,6 +15,14 @@ const (
 	v3TransferUrl = prefix + "/v3/transfer/batches"
 )
 
+// Status of v3 transfer batches.
+const (
+	V3TransferBatchStatusAccepted   = "ACCEPTED"
+	V3TransferBatchStatusProcessing = "PROCESSING"
+	V3TransferBatchStatusFinished   = "FINISHED"
+	V3TransferBatchStatusClosed     = "CLOSED"
+)
+
 // Transfer money to user. Docs:
 // https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay.php?chapter=14_2
 func (client *Client) Transfer(ctx context.Context, req TransferRequest) (*TransferResponse, error) {
@@ -37,6 +47,9 @@ type TransferRequest struct {
 }
 
 var _ requestable = (*TransferRequest)(nil)
+var _ unsignedResponder = (*TransferRequest)(nil)
+
+func (r TransferRequest) unsignedResponse() {}
 
 func (r TransferRequest) toXml(client *Client) requestXml {
 	req := transferRequestXml{}
@@ -101,6 +114,9 @@ type TransferQueryRequest struct {
 }
 
 var _ requestable = (*TransferQueryRequest)(nil)
+var _ unsignedResponder = (*TransferQueryRequest)(nil)
+
+func (r TransferQueryRequest) unsignedResponse() {}
 
 func (r TransferQueryRequest) toXml(client *Client) requestXml {
 	req := transferQueryRequestXml{}
@@ -168,7 +184,7 @@ type V3TransferRequest struct {
 	TransferAmount int
 	TransferRemark string
 	OpenId         string
-	UserName       string
+	UserName       string // encrypted with platform certificate automatically
 }
 
 var _ jsonRequestable = (*V3TransferRequests)(nil)
@@ -211,7 +227,7 @@ type v3TransferDetail struct {
 	TransferAmount int    `json:"transfer_amount"`
 	TransferRemark string `json:"transfer_remark"`
 	OpenId         string `json:"openid"`
-	UserName       string `json:"user_name,omitempty"`
+	UserName       string `json:"user_name,omitempty" sensitive:"true"`
 }
 
 type V3TransferResponse struct {
@@ -227,3 +243,130 @@ var _ responsible = (*V3TransferResponse)(nil)
 func (r V3TransferResponse) AsError() error {
 	return JsonResponseError(r.JsonResponse)
 }
+
+// Query transfer batch by BatchId or OutBatchNo. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/batch-transfer-to-balance/transfer-batch/get-transfer-batch-by-out-no.html
+func (client *Client) QueryTransferBatchV3(ctx context.Context, req V3QueryTransferBatchRequest) (*V3QueryTransferBatchResponse, error) {
+	var reqUrl string
+	if req.BatchId != "" {
+		reqUrl = v3TransferUrl + "/batch-id/" + url.PathEscape(req.BatchId)
+	} else {
+		reqUrl = v3TransferUrl + "/out-batch-no/" + url.PathEscape(req.OutBatchNo)
+	}
+	query := url.Values{}
+	query.Set("need_query_detail", strconv.FormatBool(req.NeedQueryDetail))
+	if req.NeedQueryDetail {
+		if req.Offset > 0 {
+			query.Set("offset", strconv.Itoa(req.Offset))
+		}
+		if req.Limit > 0 {
+			query.Set("limit", strconv.Itoa(req.Limit))
+		}
+		if req.DetailStatus != "" {
+			query.Set("detail_status", req.DetailStatus)
+		} else {
+			query.Set("detail_status", "ALL")
+		}
+	}
+	var res V3QueryTransferBatchResponse
+	if err := client.getJson(ctx, reqUrl+"?"+query.Encode(), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryTransferBatchRequest is used in QueryTransferBatchV3() function.
+type V3QueryTransferBatchRequest struct {
+	BatchId         string // either BatchId or OutBatchNo is required
+	OutBatchNo      string
+	NeedQueryDetail bool   // optional, set to true to get TransferDetailList
+	Offset          int    // optional
+	Limit           int    // optional, defaults to 20, max is 100
+	DetailStatus    string // optional, can be ALL (default), SUCCESS or FAIL
+}
+
+type V3QueryTransferBatchResponse struct {
+	JsonResponse
+	TransferBatch struct {
+		MchId           string     `json:"mchid"`
+		OutBatchNo      string     `json:"out_batch_no"`
+		BatchId         string     `json:"batch_id"`
+		AppId           string     `json:"appid"`
+		BatchStatus     string     `json:"batch_status"` // ACCEPTED, PROCESSING, FINISHED or CLOSED
+		BatchType       string     `json:"batch_type"`
+		BatchName       string     `json:"batch_name"`
+		BatchRemark     string     `json:"batch_remark"`
+		CloseReason     string     `json:"close_reason,omitempty"`
+		TotalAmount     int        `json:"total_amount"`
+		TotalNum        int        `json:"total_num"`
+		CreateTime      *time.Time `json:"create_time,omitempty"`
+		UpdateTime      *time.Time `json:"update_time,omitempty"`
+		SuccessAmount   int        `json:"success_amount"`
+		SuccessNum      int        `json:"success_num"`
+		FailAmount      int        `json:"fail_amount"`
+		FailNum         int        `json:"fail_num"`
+		TransferSceneId string     `json:"transfer_scene_id,omitempty"`
+	} `json:"transfer_batch"`
+	TransferDetailList []struct {
+		DetailId     string `json:"detail_id"`
+		OutDetailNo  string `json:"out_detail_no"`
+		DetailStatus string `json:"detail_status"` // INIT, WAIT_PAY, PROCESSING, SUCCESS or FAIL
+	} `json:"transfer_detail_list,omitempty"`
+}
+
+var _ responsible = (*V3QueryTransferBatchResponse)(nil)
+
+func (r V3QueryTransferBatchResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// Query transfer detail by BatchId and DetailId, or OutBatchNo and
+// OutDetailNo. Docs:
+// https://pay.weixin.qq.com/docs/merchant/apis/batch-transfer-to-balance/transfer-detail/get-transfer-detail-by-out-no.html
+func (client *Client) QueryTransferDetailV3(ctx context.Context, req V3QueryTransferDetailRequest) (*V3QueryTransferDetailResponse, error) {
+	var reqUrl string
+	if req.BatchId != "" {
+		reqUrl = v3TransferUrl + "/batch-id/" + url.PathEscape(req.BatchId) +
+			"/details/detail-id/" + url.PathEscape(req.DetailId)
+	} else {
+		reqUrl = v3TransferUrl + "/out-batch-no/" + url.PathEscape(req.OutBatchNo) +
+			"/details/out-detail-no/" + url.PathEscape(req.OutDetailNo)
+	}
+	var res V3QueryTransferDetailResponse
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryTransferDetailRequest is used in QueryTransferDetailV3() function.
+type V3QueryTransferDetailRequest struct {
+	BatchId     string // either BatchId and DetailId, or OutBatchNo and OutDetailNo are required
+	DetailId    string
+	OutBatchNo  string
+	OutDetailNo string
+}
+
+type V3QueryTransferDetailResponse struct {
+	JsonResponse
+	MchId          string     `json:"mchid"`
+	OutBatchNo     string     `json:"out_batch_no"`
+	BatchId        string     `json:"batch_id"`
+	AppId          string     `json:"appid"`
+	OutDetailNo    string     `json:"out_detail_no"`
+	DetailId       string     `json:"detail_id"`
+	DetailStatus   string     `json:"detail_status"` // INIT, WAIT_PAY, PROCESSING, SUCCESS or FAIL
+	TransferAmount int        `json:"transfer_amount"`
+	TransferRemark string     `json:"transfer_remark"`
+	FailReason     string     `json:"fail_reason,omitempty"` // e.g. ACCOUNT_FROZEN, REAL_NAME_CHECK_FAIL
+	OpenId         string     `json:"openid"`
+	UserName       string     `json:"user_name,omitempty"` // encrypted, use DecryptSensitive() to decrypt
+	InitiateTime   *time.Time `json:"initiate_time,omitempty"`
+	UpdateTime     *time.Time `json:"update_time,omitempty"`
+}
+
+var _ responsible = (*V3QueryTransferDetailResponse)(nil)
+
+func (r V3QueryTransferDetailResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
@@ -0,0 +1,170 @@
+package wxpayslim
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	v3TransferBillUrl = prefix + "/v3/fund-app/mch-transfer/transfer-bills"
+)
+
+// State of v3 transfer bills.
+const (
+	V3TransferBillStateAccepted        = "ACCEPTED"
+	V3TransferBillStateProcessing      = "PROCESSING"
+	V3TransferBillStateWaitUserConfirm = "WAIT_USER_CONFIRM" // user needs to confirm with PackageInfo
+	V3TransferBillStateTransfering     = "TRANSFERING"
+	V3TransferBillStateSuccess         = "SUCCESS"
+	V3TransferBillStateFail            = "FAIL"
+	V3TransferBillStateCanceling       = "CANCELING"
+	V3TransferBillStateCancelled       = "CANCELLED"
+)
+
+// Transfer money to user, user needs to confirm the transfer in WeChat with
+// the returned PackageInfo when State is WAIT_USER_CONFIRM. Docs:
+// https://pay.weixin.qq.com/doc/v3/merchant/4012716434
+func (client *Client) CreateTransferBillV3(ctx context.Context, req V3CreateTransferBillRequest) (*V3TransferBillResponse, error) {
+	var res V3TransferBillResponse
+	if err := client.postJson(ctx, v3TransferBillUrl, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3CreateTransferBillRequest is used in CreateTransferBillV3() function.
+type V3CreateTransferBillRequest struct {
+	AppId                    string                      // required
+	OutBillNo                string                      // required
+	TransferSceneId          string                      // required
+	OpenId                   string                      // required
+	UserName                 string                      // optional, encrypted with platform certificate automatically
+	TransferAmount           int                         // required, in cents
+	TransferRemark           string                      // required, max length is 32
+	NotifyUrl                string                      // optional
+	UserRecvPerception       string                      // optional
+	TransferSceneReportInfos []V3TransferSceneReportInfo // required, depends on TransferSceneId
+}
+
+type V3TransferSceneReportInfo struct {
+	InfoType    string `json:"info_type"`
+	InfoContent string `json:"info_content"`
+}
+
+var _ jsonRequestable = (*V3CreateTransferBillRequest)(nil)
+
+func (r V3CreateTransferBillRequest) toJson(client *Client) requestJson {
+	req := createTransferBillRequestJson{}
+	copyFields(r, &req)
+	return req
+}
+
+type createTransferBillRequestJson struct {
+	AppId                    string                      `json:"appid"`
+	OutBillNo                string                      `json:"out_bill_no"`
+	TransferSceneId          string                      `json:"transfer_scene_id"`
+	OpenId                   string                      `json:"openid"`
+	UserName                 string                      `json:"user_name,omitempty" sensitive:"true"`
+	TransferAmount           int                         `json:"transfer_amount"`
+	TransferRemark           string                      `json:"transfer_remark"`
+	NotifyUrl                string                      `json:"notify_url,omitempty"`
+	UserRecvPerception       string                      `json:"user_recv_perception,omitempty"`
+	TransferSceneReportInfos []V3TransferSceneReportInfo `json:"transfer_scene_report_infos"`
+}
+
+type V3TransferBillResponse struct {
+	JsonResponse
+	OutBillNo      string    `json:"out_bill_no"`
+	TransferBillNo string    `json:"transfer_bill_no"`
+	CreateTime     time.Time `json:"create_time"`
+	State          string    `json:"state"`
+	FailReason     string    `json:"fail_reason,omitempty"`
+	PackageInfo    string    `json:"package_info,omitempty"` // used by mini program or JSAPI to confirm the transfer
+}
+
+var _ responsible = (*V3TransferBillResponse)(nil)
+
+func (r V3TransferBillResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// Query transfer bill by OutBillNo or TransferBillNo. Docs:
+// https://pay.weixin.qq.com/doc/v3/merchant/4012716437
+func (client *Client) QueryTransferBillV3(ctx context.Context, req V3QueryTransferBillRequest) (*V3QueryTransferBillResponse, error) {
+	var reqUrl string
+	if req.TransferBillNo != "" {
+		reqUrl = v3TransferBillUrl + "/transfer-bill-no/" + url.PathEscape(req.TransferBillNo)
+	} else {
+		reqUrl = v3TransferBillUrl + "/out-bill-no/" + url.PathEscape(req.OutBillNo)
+	}
+	var res V3QueryTransferBillResponse
+	if err := client.getJson(ctx, reqUrl, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3QueryTransferBillRequest is used in QueryTransferBillV3() function.
+type V3QueryTransferBillRequest struct {
+	OutBillNo      string // either OutBillNo or TransferBillNo is required
+	TransferBillNo string
+}
+
+type V3QueryTransferBillResponse struct {
+	JsonResponse
+	V3TransferBill
+}
+
+var _ responsible = (*V3QueryTransferBillResponse)(nil)
+
+func (r V3QueryTransferBillResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
+
+// V3TransferBill is the transfer bill in v3 responses and notifications.
+type V3TransferBill struct {
+	MchId          string     `json:"mch_id"`
+	OutBillNo      string     `json:"out_bill_no"`
+	TransferBillNo string     `json:"transfer_bill_no"`
+	AppId          string     `json:"appid,omitempty"`
+	State          string     `json:"state"`
+	TransferAmount int        `json:"transfer_amount"`
+	TransferRemark string     `json:"transfer_remark,omitempty"`
+	FailReason     string     `json:"fail_reason,omitempty"`
+	OpenId         string     `json:"openid"`
+	UserName       string     `json:"user_name,omitempty"` // encrypted, use DecryptSensitive() to decrypt
+	CreateTime     *time.Time `json:"create_time,omitempty"`
+	UpdateTime     *time.Time `json:"update_time,omitempty"`
+}
+
+// Cancel transfer bill which is not confirmed by user. Docs:
+// https://pay.weixin.qq.com/doc/v3/merchant/4012716458
+func (client *Client) CancelTransferBillV3(ctx context.Context, req V3CancelTransferBillRequest) (*V3CancelTransferBillResponse, error) {
+	var res V3CancelTransferBillResponse
+	reqUrl := v3TransferBillUrl + "/out-bill-no/" + url.PathEscape(req.OutBillNo) + "/cancel"
+	if err := client.requestJson(ctx, http.MethodPost, reqUrl, nil, "", &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// V3CancelTransferBillRequest is used in CancelTransferBillV3() function.
+type V3CancelTransferBillRequest struct {
+	OutBillNo string // required
+}
+
+type V3CancelTransferBillResponse struct {
+	JsonResponse
+	OutBillNo      string    `json:"out_bill_no"`
+	TransferBillNo string    `json:"transfer_bill_no"`
+	State          string    `json:"state"` // CANCELING or CANCELLED
+	UpdateTime     time.Time `json:"update_time"`
+}
+
+var _ responsible = (*V3CancelTransferBillResponse)(nil)
+
+func (r V3CancelTransferBillResponse) AsError() error {
+	return JsonResponseError(r.JsonResponse)
+}
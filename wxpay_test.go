@@ -1,11 +1,36 @@
 package wxpayslim
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	cryptoRand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"io"
 	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type clientConfig struct {
@@ -53,6 +78,10 @@ func init() {
 }
 
 func TestCreateOrder(t *testing.T) {
+	if client == nil {
+		t.Log("client is not initialized, skipped")
+		return
+	}
 	ctx := context.Background()
 	resp, err := client.CreateOrder(ctx, CreateOrderRequest{
 		AppId:          config.Appid,
@@ -127,3 +156,1576 @@ func TestTransfer(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePayNotify(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	values := map[string]string{
+		"return_code":    "SUCCESS",
+		"result_code":    "SUCCESS",
+		"appid":          "wxxxxxxxxxxxxxxxxx",
+		"mch_id":         "1111111111",
+		"nonce_str":      "ZneDMNUuaOidCoYaQ2DAAVOkWP4kOUyf",
+		"sign_type":      "HMAC-SHA256",
+		"openid":         "oAxxxxxxxxxxxxxxxxxxxxxxxxxx",
+		"trade_type":     "JSAPI",
+		"total_fee":      "100",
+		"transaction_id": "4200000000000000000000000000",
+		"out_trade_no":   "TESTz20220311z111122",
+		"time_end":       "20220311111123",
+		"coupon_count":   "1",
+		"coupon_id_0":    "10000",
+		"coupon_fee_0":   "5",
+	}
+	body := signedXmlForTest(c, values, "HMAC-SHA256")
+
+	var got *PayNotification
+	handler := c.PayNotifyHandler(func(ctx context.Context, n *PayNotification) error {
+		got = n
+		return nil
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if !strings.Contains(w.Body.String(), "<return_code>SUCCESS</return_code>") {
+		t.Error("expected SUCCESS reply, got:", w.Body.String())
+	}
+	if got == nil || !got.Paid() || got.TotalFee != 100 || got.OutTradeNo != values["out_trade_no"] ||
+		len(got.Coupons) != 1 || got.Coupons[0].CouponId != "10000" || got.Coupons[0].CouponFee != 5 {
+		t.Errorf("unexpected notification: %+v", got)
+	}
+
+	tampered := strings.Replace(body, "<![CDATA[100]]>", "<![CDATA[1]]>", 1)
+	_, err := c.ParsePayNotify(httptest.NewRequest("POST", "/", strings.NewReader(tampered)))
+	if _, ok := err.(InvalidSignError); !ok {
+		t.Error("expected InvalidSignError, got:", err)
+	}
+}
+
+func TestParseRefundNotify(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	info := "<root><out_refund_no><![CDATA[R20220311]]></out_refund_no>" +
+		"<refund_fee><![CDATA[100]]></refund_fee>" +
+		"<refund_status><![CDATA[SUCCESS]]></refund_status>" +
+		"<success_time><![CDATA[2022-03-11 11:11:23]]></success_time></root>"
+	reqInfo := encryptReqInfoForTest(t, c, info)
+	body := "<xml><return_code>SUCCESS</return_code><appid>wxxxxxxxxxxxxxxxxx</appid>" +
+		"<mch_id>1111111111</mch_id><req_info><![CDATA[" + reqInfo + "]]></req_info></xml>"
+	n, err := c.ParseRefundNotify(httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	if !n.Refunded() || n.OutRefundNo != "R20220311" || n.RefundFee != 100 || n.SuccessTime == nil {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+}
+
+func encryptReqInfoForTest(t *testing.T, c *Client, info string) string {
+	h := md5.Sum([]byte(c.Key))
+	block, err := aes.NewCipher([]byte(hex.EncodeToString(h[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(info)%aes.BlockSize
+	data := append([]byte(info), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Encrypt(data[i:i+aes.BlockSize], data[i:i+aes.BlockSize])
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestResponseErrorIs(t *testing.T) {
+	var res CloseOrderResponse
+	err := xml.Unmarshal([]byte("<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code>"+
+		"<err_code>ORDERPAID</err_code><err_code_des>订单已支付</err_code_des></xml>"), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success() {
+		t.Error("expected response to be failed")
+	}
+	err = res.AsError()
+	if !errors.Is(err, ErrOrderPaid) {
+		t.Error("expected error to be ErrOrderPaid:", err)
+	}
+	if errors.Is(err, ErrSystemError) {
+		t.Error("expected error not to be ErrSystemError:", err)
+	}
+}
+
+func TestParseBill(t *testing.T) {
+	data := "\ufeff交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,应结订单金额,代金券金额,微信退款单号,商户退款单号,退款金额,充值券退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率,订单金额,申请退款金额,费率备注\r\n" +
+		"`2022-03-11 11:11:23,`wxxxxxxxxxxxxxxxxx,`1111111111,`0,`,`4200000000000000000000000000,`TESTz20220311z111122,`oAxxxxxxxxxxxxxxxxxxxxxxxxxx,`JSAPI,`SUCCESS,`OTHERS,`CNY,`1.00,`0.00,`0,`0,`0.00,`0.00,`,`,`apple, banana,`a,b,`0.01000,`0.60%,`1.00,`0.00,`\r\n" +
+		"总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额\r\n" +
+		"`1,`1.00,`0.00,`0.00,`0.01000,`1.00,`0.00\r\n"
+	var bill Bill
+	if err := parseBill([]byte(data), &bill.Records, &bill.Summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(bill.Records) != 1 {
+		t.Fatal("expected 1 record, got", len(bill.Records))
+	}
+	r := bill.Records[0]
+	if r.TradeTime != "2022-03-11 11:11:23" || r.OutTradeNo != "TESTz20220311z111122" || r.TotalFee != "1.00" || r.Rate != "0.60%" ||
+		r.Body != "apple, banana" || r.Attach != "a,b" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if bill.Summary.TotalCount != "1" || bill.Summary.ServiceCharge != "0.01000" {
+		t.Errorf("unexpected summary: %+v", bill.Summary)
+	}
+}
+
+func TestQueryRefundOrderResponse(t *testing.T) {
+	var res QueryRefundOrderResponse
+	err := xml.Unmarshal([]byte(`<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code>
+<total_refund_count>2</total_refund_count><refund_count>2</refund_count>
+<out_refund_no_0>R1</out_refund_no_0><refund_fee_0>100</refund_fee_0><refund_status_0>SUCCESS</refund_status_0>
+<out_refund_no_1>R2</out_refund_no_1><refund_fee_1>200</refund_fee_1><refund_status_1>PROCESSING</refund_status_1>
+<coupon_refund_count_1>2</coupon_refund_count_1>
+<coupon_refund_id_1_0>C1</coupon_refund_id_1_0><coupon_refund_fee_1_0>10</coupon_refund_fee_1_0>
+<coupon_refund_id_1_1>C2</coupon_refund_id_1_1><coupon_refund_fee_1_1>20</coupon_refund_fee_1_1>
+</xml>`), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OutRefundNo0 != "R1" || !res.Refunded() {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	if len(res.Refunds) != 2 {
+		t.Fatal("expected 2 refunds, got", len(res.Refunds))
+	}
+	r := res.Refunds[1]
+	if r.OutRefundNo != "R2" || r.RefundFee != 200 || r.RefundStatus != "PROCESSING" || len(r.Coupons) != 2 ||
+		r.Coupons[1].CouponRefundId != "C2" || r.Coupons[1].CouponRefundFee != 20 {
+		t.Errorf("unexpected refund: %+v", r)
+	}
+}
+
+func TestQueryAllRefundOrders(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	var offsets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		req, err := parseXmlValues(b)
+		if err != nil {
+			t.Error(err)
+		}
+		offsets = append(offsets, req["offset"])
+		offset, _ := strconv.Atoi(req["offset"])
+		// 13 refunds in total, 10 in the first page and 2 in the others
+		count, size := 13-offset, 10
+		if offset > 0 {
+			size = 2
+		}
+		if count > size {
+			count = size
+		}
+		res := map[string]string{
+			"return_code":        "SUCCESS",
+			"result_code":        "SUCCESS",
+			"nonce_str":          randomStr(32),
+			"total_refund_count": "13",
+			"refund_count":       strconv.Itoa(count),
+		}
+		for i := 0; i < count; i++ {
+			n := strconv.Itoa(i)
+			res["out_refund_no_"+n] = "R" + strconv.Itoa(offset+i)
+			res["refund_fee_"+n] = "100"
+			res["refund_status_"+n] = "SUCCESS"
+		}
+		w.Write([]byte(signedXmlForTest(c, res, "MD5")))
+	}))
+	defer server.Close()
+	redirectForTest(c, server)
+
+	res, err := c.QueryAllRefundOrders(context.Background(), QueryRefundOrderRequest{
+		AppId:      "wxxxxxxxxxxxxxxxxx",
+		OutTradeNo: "TESTz20220311z111122",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(offsets, ",") != ",10,12" {
+		t.Errorf("unexpected offsets: %q", offsets)
+	}
+	if len(res.Refunds) != 13 {
+		t.Fatal("expected 13 refunds, got", len(res.Refunds))
+	}
+	for i, refund := range res.Refunds {
+		if refund.OutRefundNo != "R"+strconv.Itoa(i) {
+			t.Errorf("unexpected refund %d: %+v", i, refund)
+		}
+	}
+}
+
+func TestPostXmlVerifySign(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	values := map[string]string{
+		"return_code": "SUCCESS",
+		"result_code": "SUCCESS",
+		"appid":       "wxxxxxxxxxxxxxxxxx",
+		"mch_id":      "1111111111",
+		"nonce_str":   "ZneDMNUuaOidCoYaQ2DAAVOkWP4kOUyf",
+		"trade_state": "SUCCESS",
+		"total_fee":   "100",
+	}
+	response := signedXmlForTest(c, values, "HMAC-SHA256")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	req := QueryOrderRequest{AppId: values["appid"], OutTradeNo: "TESTz20220311z111122"}
+	var res QueryOrderResponse
+	if err := c.postXml(ctx, server.URL, req, &res); err == nil {
+		t.Error("expected MD5 verification of HMAC-SHA256 sign to fail")
+	}
+	refund := RefundOrderRequest{AppId: values["appid"], SignType: "HMAC-SHA256"}
+	var refundRes RefundOrderResponse
+	if err := c.postXml(ctx, server.URL, refund, &refundRes); err != nil {
+		t.Error("expected error to be nil:", err)
+	}
+	response = strings.Replace(response, "<![CDATA[100]]>", "<![CDATA[1]]>", 1)
+	if err := c.postXml(ctx, server.URL, refund, &refundRes); !errors.As(err, &InvalidSignError{}) {
+		t.Error("expected InvalidSignError, got:", err)
+	}
+}
+
+func TestDecryptResource(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	c.APIv3Key = "yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy"
+	resource := encryptResourceForTest(t, c, "certificate", []byte("hello"))
+	b, err := c.decryptResource(resource)
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	if string(b) != "hello" {
+		t.Error("unexpected plaintext:", string(b))
+	}
+	resource.AssociatedData = "transaction"
+	if _, err := c.decryptResource(resource); err == nil {
+		t.Error("expected error with wrong associated data")
+	}
+}
+
+func encryptResourceForTest(t *testing.T, c *Client, associatedData string, plaintext []byte) V3EncryptedResource {
+	block, err := aes.NewCipher([]byte(c.APIv3Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := randomStr(12)
+	return V3EncryptedResource{
+		Algorithm:      "AEAD_AES_256_GCM",
+		Nonce:          nonce,
+		AssociatedData: associatedData,
+		Ciphertext:     base64.StdEncoding.EncodeToString(gcm.Seal(nil, []byte(nonce), plaintext, []byte(associatedData))),
+	}
+}
+
+func TestVerifyResponse(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	key, cert := generateCertificateForTest(t)
+	c.platformCertificates.set(map[string]*x509.Certificate{"5157F09EFDC096DE15EBE81A47057A7232F1B8E1": cert})
+	body := []byte(`{"batch_id":"1030000071100999991182020050700019480001"}`)
+	ctx := context.Background()
+
+	header := signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now(), body)
+	if err := c.verifyResponse(ctx, header, body); err != nil {
+		t.Error("expected error to be nil:", err)
+	}
+	if err := c.verifyResponse(ctx, header, []byte(`{}`)); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError, got:", err)
+	}
+	header = signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now().Add(-10*time.Minute), body)
+	if err := c.verifyResponse(ctx, header, body); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError for stale timestamp, got:", err)
+	}
+	header = signHeaderForTest(t, key, "PUB_KEY_ID_0000000000000000000000000000000000", time.Now(), body)
+	if err := c.verifyResponse(ctx, header, body); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError for unknown public key, got:", err)
+	}
+	c.platformPublicKeyId = "PUB_KEY_ID_0000000000000000000000000000000000"
+	c.platformPublicKey = &key.PublicKey
+	if err := c.verifyResponse(ctx, header, body); err != nil {
+		t.Error("expected error to be nil:", err)
+	}
+}
+
+func generateCertificateForTest(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(cryptoRand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(cryptoRand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func signHeaderForTest(t *testing.T, key *rsa.PrivateKey, serial string, tm time.Time, body []byte) http.Header {
+	timestamp := strconv.FormatInt(tm.Unix(), 10)
+	nonce := randomStr(32)
+	h := sha256.Sum256([]byte(timestamp + "\n" + nonce + "\n" + string(body) + "\n"))
+	signature, err := rsa.SignPKCS1v15(cryptoRand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("Wechatpay-Serial", serial)
+	header.Set("Wechatpay-Timestamp", timestamp)
+	header.Set("Wechatpay-Nonce", nonce)
+	header.Set("Wechatpay-Signature", base64.StdEncoding.EncodeToString(signature))
+	return header
+}
+
+func TestParseNotifyV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	c.APIv3Key = "yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy"
+	key, cert := generateCertificateForTest(t)
+	c.platformCertificates.set(map[string]*x509.Certificate{"5157F09EFDC096DE15EBE81A47057A7232F1B8E1": cert})
+	resource := encryptResourceForTest(t, c, "transaction", []byte(`{"mchid":"1111111111",`+
+		`"out_trade_no":"TESTz20220311z111122","trade_state":"SUCCESS","amount":{"total":100}}`))
+	body, err := json.Marshal(V3Notification{
+		Id:           "EV-2018022511223320873",
+		EventType:    EventTransactionSuccess,
+		ResourceType: "encrypt-resource",
+		Resource:     resource,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *V3Transaction
+	handler := c.NotifyHandlerV3(func(ctx context.Context, n *V3Notification) error {
+		var err error
+		got, err = n.Transaction()
+		return err
+	})
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header = signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now(), body)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Error("expected status 204, got:", w.Code, w.Body.String())
+	}
+	if got == nil || !got.Paid() || got.OutTradeNo != "TESTz20220311z111122" || got.Amount.Total != 100 {
+		t.Errorf("unexpected transaction: %+v", got)
+	}
+
+	req = httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header = signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now(), []byte("{}"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Error("expected status 500, got:", w.Code)
+	}
+}
+
+func TestJSAPIPayParamsV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	key := setCertificateForTest(t, c)
+	p, err := c.JSAPIPayParamsV3("wxxxxxxxxxxxxxxxxx", "wx201410272009395522657a690389285100")
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	signature, _ := base64.StdEncoding.DecodeString(p.PaySign)
+	h := sha256.Sum256([]byte(p.AppId + "\n" + p.TimeStamp + "\n" + p.NonceStr + "\n" + p.Package + "\n"))
+	if p.SignType != "RSA" || rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, h[:], signature) != nil {
+		t.Errorf("unexpected pay params: %+v", p)
+	}
+	if _, err := NewClient("1111111111", "").AppPayParamsV3("wxxxxxxxxxxxxxxxxx", "prepay"); err == nil {
+		t.Error("expected error without certificate")
+	}
+
+	app, err := c.AppPayParamsV3("wxxxxxxxxxxxxxxxxx", "wx201410272009395522657a690389285100")
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	signature, _ = base64.StdEncoding.DecodeString(app.Sign)
+	h = sha256.Sum256([]byte(app.AppId + "\n" + app.TimeStamp + "\n" + app.NonceStr + "\n" + app.PrepayId + "\n"))
+	if app.PartnerId != "1111111111" || app.Package != "Sign=WXPay" ||
+		rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, h[:], signature) != nil {
+		t.Errorf("unexpected app pay params: %+v", app)
+	}
+	app, err = c.SubMerchant("2222222222", "").AppPayParamsV3("wxxxxxxxxxxxxxxxxx", "wx201410272009395522657a690389285100")
+	if err != nil || app.PartnerId != "2222222222" {
+		t.Errorf("unexpected sub-merchant app pay params: %+v, %v", app, err)
+	}
+}
+
+func TestJSAPIPayParamsWithSignType(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	for _, signType := range []string{"MD5", "HMAC-SHA256"} {
+		p, err := c.JSAPIPayParamsWithSignType("wxxxxxxxxxxxxxxxxx", "wx201410272009395522657a690389285100", signType)
+		if err != nil {
+			t.Fatal("expected error to be nil:", err)
+		}
+		sign := c.signString(joinStringToSign(map[string]string{
+			"appId":     p.AppId,
+			"nonceStr":  p.NonceStr,
+			"package":   p.Package,
+			"signType":  signType,
+			"timeStamp": p.TimeStamp,
+		}, c.Key), signType)
+		if p.SignType != signType || p.PaySign != sign {
+			t.Errorf("unexpected %s pay params: %+v", signType, p)
+		}
+	}
+	if p := c.JSAPIPayParams("wxxxxxxxxxxxxxxxxx", "wx201410272009395522657a690389285100"); p.SignType != "MD5" || len(p.PaySign) != 32 {
+		t.Errorf("unexpected pay params: %+v", p)
+	}
+	if _, err := c.JSAPIPayParamsWithSignType("wxxxxxxxxxxxxxxxxx", "wx201410272009395522657a690389285100", "RSA"); err == nil {
+		t.Error("expected error for RSA sign type")
+	}
+}
+
+func TestGetJson(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	key, cert := generateCertificateForTest(t)
+	c.platformCertificates.set(map[string]*x509.Certificate{"5157F09EFDC096DE15EBE81A47057A7232F1B8E1": cert})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if r.Method != "GET" || r.URL.RequestURI() != "/v3/pay/transactions/out-trade-no/T1?mchid=1111111111" ||
+			!strings.HasPrefix(auth, "WECHATPAY2-SHA256-RSA2048 mchid=\"1111111111\"") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body := []byte(`{"out_trade_no":"T1","trade_state":"SUCCESS","promotion_detail":[{"coupon_id":"109519","amount":5}]}`)
+		for k, v := range signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now(), body) {
+			w.Header()[k] = v
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+	var res V3QueryOrderResponse
+	err := c.getJson(context.Background(), server.URL+"/v3/pay/transactions/out-trade-no/T1?mchid=1111111111", &res)
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	if !res.Paid() || len(res.PromotionDetail) != 1 || res.PromotionDetail[0].Amount != 5 {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
+func TestUpdateCertificatesUnknownSerial(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	c.APIv3Key = "yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy"
+	setCertificateForTest(t, c)
+	key, _ := generateCertificateForTest(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body := []byte(`{"data":[]}`)
+		for k, v := range signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now(), body) {
+			w.Header()[k] = v
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+	redirectForTest(c, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.UpdateCertificates(ctx); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError, got:", err)
+	}
+	body := []byte(`{}`)
+	header := signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now(), body)
+	if err := c.verifyResponse(ctx, header, body); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError, got:", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Error("expected certificates to be downloaded twice, got", n)
+	}
+
+	// no more downloads within MinCertificatesUpdateInterval
+	if err := c.verifyResponse(ctx, header, body); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError, got:", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Error("expected certificates not to be downloaded again, got", n)
+	}
+
+	// concurrent calls download once, expired or invalid signatures never
+	c = NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	c.APIv3Key = "yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy"
+	setCertificateForTest(t, c)
+	redirectForTest(c, server)
+	expired := signHeaderForTest(t, key, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now().Add(-10*time.Minute), body)
+	if err := c.verifyResponse(ctx, expired, body); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError, got:", err)
+	}
+	invalid := header.Clone()
+	invalid.Set("Wechatpay-Signature", "")
+	if err := c.verifyResponse(ctx, invalid, body); !errors.As(err, &SignatureVerificationError{}) {
+		t.Error("expected SignatureVerificationError, got:", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Error("expected certificates not to be downloaded for invalid headers, got", n)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.verifyResponse(ctx, header, body); !errors.As(err, &SignatureVerificationError{}) {
+				t.Error("expected SignatureVerificationError, got:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Error("expected certificates to be downloaded once more, got", n)
+	}
+}
+
+// v3ServerForTest starts a server replying status and body returned by reply,
+// signed with a new platform public key set to the client. Requests of the
+// client are sent to the server. The private key is returned to decrypt
+// sensitive fields.
+func v3ServerForTest(t *testing.T, c *Client, reply func(r *http.Request, body []byte) (int, string)) (*httptest.Server, *rsa.PrivateKey) {
+	key, _ := generateCertificateForTest(t)
+	c.platformPublicKeyId = "PUB_KEY_ID_0111111111112025010100000000000000"
+	c.platformPublicKey = &key.PublicKey
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := io.ReadAll(r.Body)
+		status, resBody := reply(r, reqBody)
+		for k, v := range signHeaderForTest(t, key, c.platformPublicKeyId, time.Now(), []byte(resBody)) {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(resBody))
+	}))
+	redirectForTest(c, server)
+	return server, key
+}
+
+func TestCreateOrderV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	var got map[string]interface{}
+	server, _ := v3ServerForTest(t, c, func(r *http.Request, body []byte) (int, string) {
+		got = nil
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+		switch r.URL.Path {
+		case "/v3/pay/transactions/jsapi":
+			return 200, `{"prepay_id":"wx201410272009395522657a690389285100"}`
+		case "/v3/pay/transactions/native":
+			return 200, `{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`
+		case "/v3/pay/transactions/h5":
+			return 200, `{"h5_url":"https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx1"}`
+		}
+		return 404, `{"code":"NOT_FOUND","message":"not found"}`
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	req := V3CreateOrderRequest{
+		AppId:       "wxxxxxxxxxxxxxxxxx",
+		Description: "test",
+		OutTradeNo:  "TESTz20220311z111122",
+		TimeExpire:  time.Date(2022, 3, 11, 11, 11, 23, 0, time.FixedZone("UTC+8", 8*60*60)),
+		NotifyUrl:   "https://example.com/notify",
+		Amount:      V3Amount{Total: 100, Currency: "CNY"},
+		Payer:       &V3Payer{OpenId: "oAxxxxxxxxxxxxxxxxxxxxxxxxxx"},
+	}
+	res, err := c.CreateJSAPIOrderV3(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PrepayId != "wx201410272009395522657a690389285100" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	amount, _ := got["amount"].(map[string]interface{})
+	payer, _ := got["payer"].(map[string]interface{})
+	if got["mchid"] != "1111111111" || got["appid"] != req.AppId || got["time_expire"] != "2022-03-11T11:11:23+08:00" ||
+		amount["total"] != 100.0 || amount["currency"] != "CNY" || payer["openid"] != "oAxxxxxxxxxxxxxxxxxxxxxxxxxx" {
+		t.Errorf("unexpected request: %v", got)
+	}
+
+	req.Payer = nil
+	req.TimeExpire = time.Time{}
+	res, err = c.CreateNativeOrderV3(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CodeUrl != "weixin://wxpay/bizpayurl?pr=p4lpSuKzz" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	if _, ok := got["payer"]; ok {
+		t.Error("expected payer to be omitted")
+	}
+	if _, ok := got["time_expire"]; ok {
+		t.Error("expected time_expire to be omitted")
+	}
+
+	req.SceneInfo = &V3SceneInfo{PayerClientIp: "127.0.0.1", H5Info: &V3H5Info{Type: "Wap"}}
+	res, err = c.CreateH5OrderV3(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.H5Url != "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx1" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	scene, _ := got["scene_info"].(map[string]interface{})
+	if h5, _ := scene["h5_info"].(map[string]interface{}); h5["type"] != "Wap" {
+		t.Errorf("unexpected request: %v", got)
+	}
+}
+
+func decryptSensitiveForTest(t *testing.T, key *rsa.PrivateKey, ciphertext string) string {
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := rsa.DecryptOAEP(sha1.New(), cryptoRand.Reader, key, b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(plaintext)
+}
+
+func TestRefundOrderV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	var got map[string]interface{}
+	var gotSerial, gotUri string
+	refund := `{"refund_id":"50000000382019052709732678859","out_refund_no":"R1","out_trade_no":"T1",` +
+		`"channel":"ORIGINAL","create_time":"2022-03-11T11:11:23+08:00","status":"PROCESSING",` +
+		`"amount":{"total":100,"refund":50,"payer_refund":50,"currency":"CNY"}}`
+	server, key := v3ServerForTest(t, c, func(r *http.Request, body []byte) (int, string) {
+		got = nil
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Error(err)
+			}
+		}
+		gotSerial = r.Header.Get("Wechatpay-Serial")
+		gotUri = r.URL.RequestURI()
+		return 200, refund
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	res, err := c.RefundOrderV3(ctx, V3RefundOrderRequest{
+		OutTradeNo:  "T1",
+		OutRefundNo: "R1",
+		Amount:      V3RefundAmount{Refund: 50, Total: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/refund/domestic/refunds" || gotSerial != "" {
+		t.Errorf("unexpected request: %s %s", gotUri, gotSerial)
+	}
+	amount, _ := got["amount"].(map[string]interface{})
+	if got["out_trade_no"] != "T1" || amount["refund"] != 50.0 || amount["currency"] != "CNY" {
+		t.Errorf("unexpected request: %v", got)
+	}
+	if res.RefundId != "50000000382019052709732678859" || res.Status != V3RefundStatusProcessing ||
+		res.Amount.Refund != 50 || res.Amount.PayerRefund != 50 || res.CreateTime.IsZero() {
+		t.Errorf("unexpected response: %+v", res)
+	}
+
+	sub := c.SubMerchant("2222222222", "")
+	if _, err := sub.QueryRefundOrderV3(ctx, V3QueryRefundOrderRequest{OutRefundNo: "R/1"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/refund/domestic/refunds/R%2F1?sub_mchid=2222222222" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+
+	_, err = c.ApplyAbnormalRefundV3(ctx, V3ApplyAbnormalRefundRequest{
+		RefundId:    "50000000382019052709732678859",
+		OutRefundNo: "R1",
+		Type:        "USER_BANK_CARD",
+		BankType:    "ICBC_DEBIT",
+		BankAccount: "6222000000000000000",
+		RealName:    "张三",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/refund/domestic/refunds/50000000382019052709732678859/apply-abnormal-refund" ||
+		gotSerial != c.platformPublicKeyId {
+		t.Errorf("unexpected request: %s %s", gotUri, gotSerial)
+	}
+	if got["out_refund_no"] != "R1" || got["bank_type"] != "ICBC_DEBIT" ||
+		decryptSensitiveForTest(t, key, got["bank_account"].(string)) != "6222000000000000000" ||
+		decryptSensitiveForTest(t, key, got["real_name"].(string)) != "张三" {
+		t.Errorf("unexpected request: %v", got)
+	}
+}
+
+func TestQueryTransferV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	var gotUri string
+	server, _ := v3ServerForTest(t, c, func(r *http.Request, body []byte) (int, string) {
+		gotUri = r.URL.RequestURI()
+		if strings.Contains(r.URL.Path, "/details/") {
+			return 200, `{"out_batch_no":"B1","out_detail_no":"D1","detail_status":"SUCCESS","transfer_amount":100}`
+		}
+		return 200, `{"transfer_batch":{"out_batch_no":"B1","batch_status":"FINISHED","total_num":2},` +
+			`"transfer_detail_list":[{"out_detail_no":"D1","detail_status":"SUCCESS"},{"out_detail_no":"D2","detail_status":"FAIL"}]}`
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	tests := []struct {
+		req V3QueryTransferBatchRequest
+		uri string
+	}{
+		{
+			V3QueryTransferBatchRequest{OutBatchNo: "B1"},
+			"/v3/transfer/batches/out-batch-no/B1?need_query_detail=false",
+		},
+		{
+			V3QueryTransferBatchRequest{OutBatchNo: "B1", NeedQueryDetail: true},
+			"/v3/transfer/batches/out-batch-no/B1?detail_status=ALL&need_query_detail=true",
+		},
+		{
+			V3QueryTransferBatchRequest{BatchId: "1030000071100999991182020050700019480001", NeedQueryDetail: true, Offset: 20, Limit: 100, DetailStatus: "FAIL"},
+			"/v3/transfer/batches/batch-id/1030000071100999991182020050700019480001?detail_status=FAIL&limit=100&need_query_detail=true&offset=20",
+		},
+	}
+	for _, test := range tests {
+		res, err := c.QueryTransferBatchV3(ctx, test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotUri != test.uri {
+			t.Errorf("expected request uri %s, got %s", test.uri, gotUri)
+		}
+		if res.TransferBatch.BatchStatus != "FINISHED" || len(res.TransferDetailList) != 2 ||
+			res.TransferDetailList[1].DetailStatus != "FAIL" {
+			t.Errorf("unexpected response: %+v", res)
+		}
+	}
+
+	detail, err := c.QueryTransferDetailV3(ctx, V3QueryTransferDetailRequest{OutBatchNo: "B1", OutDetailNo: "D1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/transfer/batches/out-batch-no/B1/details/out-detail-no/D1" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+	if detail.DetailStatus != "SUCCESS" || detail.TransferAmount != 100 {
+		t.Errorf("unexpected response: %+v", detail)
+	}
+	if _, err := c.QueryTransferDetailV3(ctx, V3QueryTransferDetailRequest{BatchId: "X1", DetailId: "Y1"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/transfer/batches/batch-id/X1/details/detail-id/Y1" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+}
+
+func TestTransferBillV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	var got map[string]interface{}
+	var gotMethod, gotUri, gotSerial string
+	server, key := v3ServerForTest(t, c, func(r *http.Request, body []byte) (int, string) {
+		got = nil
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Error(err)
+			}
+		}
+		gotMethod = r.Method
+		gotUri = r.URL.RequestURI()
+		gotSerial = r.Header.Get("Wechatpay-Serial")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/cancel"):
+			return 200, `{"out_bill_no":"B1","transfer_bill_no":"1330000071100999991182020050700019480001",` +
+				`"state":"CANCELING","update_time":"2025-01-01T12:00:00+08:00"}`
+		case r.Method == http.MethodGet:
+			return 200, `{"mch_id":"1111111111","out_bill_no":"B1","transfer_bill_no":"1330000071100999991182020050700019480001",` +
+				`"state":"SUCCESS","transfer_amount":100,"openid":"oAxxxxxxxxxxxxxxxxxxxxxxxxxx","create_time":"2025-01-01T12:00:00+08:00"}`
+		}
+		return 200, `{"out_bill_no":"B1","transfer_bill_no":"1330000071100999991182020050700019480001",` +
+			`"create_time":"2025-01-01T12:00:00+08:00","state":"WAIT_USER_CONFIRM","package_info":"affffddafdfafddffda=="}`
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	res, err := c.CreateTransferBillV3(ctx, V3CreateTransferBillRequest{
+		AppId:           "wxxxxxxxxxxxxxxxxx",
+		OutBillNo:       "B1",
+		TransferSceneId: "1000",
+		OpenId:          "oAxxxxxxxxxxxxxxxxxxxxxxxxxx",
+		UserName:        "张三",
+		TransferAmount:  100,
+		TransferRemark:  "test",
+		TransferSceneReportInfos: []V3TransferSceneReportInfo{
+			{InfoType: "活动名称", InfoContent: "新会员有礼"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "POST" || gotUri != "/v3/fund-app/mch-transfer/transfer-bills" || gotSerial != c.platformPublicKeyId {
+		t.Errorf("unexpected request: %s %s %s", gotMethod, gotUri, gotSerial)
+	}
+	infos, _ := got["transfer_scene_report_infos"].([]interface{})
+	if got["out_bill_no"] != "B1" || got["transfer_amount"] != 100.0 || got["transfer_scene_id"] != "1000" ||
+		len(infos) != 1 || decryptSensitiveForTest(t, key, got["user_name"].(string)) != "张三" {
+		t.Errorf("unexpected request: %v", got)
+	}
+	if res.State != V3TransferBillStateWaitUserConfirm || res.PackageInfo != "affffddafdfafddffda==" || res.CreateTime.IsZero() {
+		t.Errorf("unexpected response: %+v", res)
+	}
+
+	bill, err := c.QueryTransferBillV3(ctx, V3QueryTransferBillRequest{OutBillNo: "B1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/fund-app/mch-transfer/transfer-bills/out-bill-no/B1" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+	if bill.State != V3TransferBillStateSuccess || bill.TransferAmount != 100 || bill.CreateTime == nil {
+		t.Errorf("unexpected response: %+v", bill)
+	}
+	if _, err := c.QueryTransferBillV3(ctx, V3QueryTransferBillRequest{TransferBillNo: "1330000071100999991182020050700019480001"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/fund-app/mch-transfer/transfer-bills/transfer-bill-no/1330000071100999991182020050700019480001" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+
+	cancelled, err := c.CancelTransferBillV3(ctx, V3CancelTransferBillRequest{OutBillNo: "B1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "POST" || gotUri != "/v3/fund-app/mch-transfer/transfer-bills/out-bill-no/B1/cancel" {
+		t.Errorf("unexpected request: %s %s", gotMethod, gotUri)
+	}
+	if cancelled.State != V3TransferBillStateCanceling || cancelled.UpdateTime.IsZero() {
+		t.Errorf("unexpected response: %+v", cancelled)
+	}
+}
+
+func TestProfitSharingV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	var got map[string]interface{}
+	var gotMethod, gotUri, gotSerial string
+	server, key := v3ServerForTest(t, c, func(r *http.Request, body []byte) (int, string) {
+		got = nil
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Error(err)
+			}
+		}
+		gotMethod = r.Method
+		gotUri = r.URL.RequestURI()
+		gotSerial = r.Header.Get("Wechatpay-Serial")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v3/profitsharing/receivers/"):
+			return 200, `{"type":"MERCHANT_ID","account":"86693852"}`
+		case strings.HasPrefix(r.URL.Path, "/v3/profitsharing/return-orders"):
+			return 200, `{"order_id":"3008450740201411110007820472","out_return_no":"R1","return_mchid":"86693852",` +
+				`"amount":10,"result":"SUCCESS","create_time":"2025-01-01T12:00:00+08:00"}`
+		case strings.HasPrefix(r.URL.Path, "/v3/profitsharing/transactions/"):
+			return 200, `{"transaction_id":"4208450740201411110007820472","unsplit_amount":1000}`
+		}
+		return 200, `{"transaction_id":"4208450740201411110007820472","out_order_no":"P1","order_id":"3008450740201411110007820472",` +
+			`"state":"PROCESSING","receivers":[{"type":"MERCHANT_ID","account":"86693852","amount":100,"result":"PENDING"}]}`
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	res, err := c.ProfitSharingV3(ctx, V3ProfitSharingRequest{
+		AppId:         "wxxxxxxxxxxxxxxxxx",
+		TransactionId: "4208450740201411110007820472",
+		OutOrderNo:    "P1",
+		Receivers: []V3ProfitSharingReceiver{
+			{Type: ProfitSharingReceiverMerchant, Account: "86693852", Name: "测试商户", Amount: 100, Description: "分给商户"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "POST" || gotUri != "/v3/profitsharing/orders" || gotSerial != c.platformPublicKeyId {
+		t.Errorf("unexpected request: %s %s %s", gotMethod, gotUri, gotSerial)
+	}
+	receivers, _ := got["receivers"].([]interface{})
+	if got["appid"] != "wxxxxxxxxxxxxxxxxx" || got["out_order_no"] != "P1" || got["unfreeze_unsplit"] != false || len(receivers) != 1 {
+		t.Fatalf("unexpected request: %v", got)
+	}
+	receiver, _ := receivers[0].(map[string]interface{})
+	if receiver["amount"] != 100.0 || decryptSensitiveForTest(t, key, receiver["name"].(string)) != "测试商户" {
+		t.Errorf("unexpected receiver: %v", receiver)
+	}
+	if res.OrderId != "3008450740201411110007820472" || len(res.Receivers) != 1 || res.Receivers[0].Result != "PENDING" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+
+	if _, err := c.QueryProfitSharingV3(ctx, V3QueryProfitSharingRequest{TransactionId: "4208450740201411110007820472", OutOrderNo: "P1"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "GET" || gotUri != "/v3/profitsharing/orders/P1?transaction_id=4208450740201411110007820472" {
+		t.Errorf("unexpected request: %s %s", gotMethod, gotUri)
+	}
+
+	if _, err := c.UnfreezeProfitSharingV3(ctx, V3UnfreezeProfitSharingRequest{
+		TransactionId: "4208450740201411110007820472",
+		OutOrderNo:    "P2",
+		Description:   "解冻",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/orders/unfreeze" || got["out_order_no"] != "P2" || got["description"] != "解冻" || gotSerial != "" {
+		t.Errorf("unexpected request: %s %v", gotUri, got)
+	}
+
+	ret, err := c.ReturnProfitSharingV3(ctx, V3ReturnProfitSharingRequest{
+		OutOrderNo:  "P1",
+		OutReturnNo: "R1",
+		ReturnMchId: "86693852",
+		Amount:      10,
+		Description: "回退",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/return-orders" || got["return_mchid"] != "86693852" || got["amount"] != 10.0 {
+		t.Errorf("unexpected request: %s %v", gotUri, got)
+	}
+	if _, ok := got["order_id"]; ok {
+		t.Error("expected order_id to be omitted")
+	}
+	if ret.Result != "SUCCESS" || ret.Amount != 10 || ret.CreateTime == nil {
+		t.Errorf("unexpected response: %+v", ret)
+	}
+	if _, err := c.QueryProfitSharingReturnV3(ctx, V3QueryProfitSharingReturnRequest{OutOrderNo: "P1", OutReturnNo: "R1"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/return-orders/R1?out_order_no=P1" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+
+	amount, err := c.QueryProfitSharingAmountV3(ctx, "4208450740201411110007820472")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/transactions/4208450740201411110007820472/amounts" || amount.UnsplitAmount != 1000 {
+		t.Errorf("unexpected request or response: %s %+v", gotUri, amount)
+	}
+
+	if _, err := c.AddProfitSharingReceiverV3(ctx, V3AddProfitSharingReceiverRequest{
+		AppId:        "wxxxxxxxxxxxxxxxxx",
+		Type:         ProfitSharingReceiverMerchant,
+		Account:      "86693852",
+		Name:         "测试商户",
+		RelationType: "SERVICE_PROVIDER",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/receivers/add" || gotSerial != c.platformPublicKeyId ||
+		got["relation_type"] != "SERVICE_PROVIDER" || decryptSensitiveForTest(t, key, got["name"].(string)) != "测试商户" {
+		t.Errorf("unexpected request: %s %s %v", gotUri, gotSerial, got)
+	}
+	if _, err := c.RemoveProfitSharingReceiverV3(ctx, V3RemoveProfitSharingReceiverRequest{
+		AppId:   "wxxxxxxxxxxxxxxxxx",
+		Type:    ProfitSharingReceiverMerchant,
+		Account: "86693852",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/receivers/delete" || got["account"] != "86693852" || gotSerial != "" {
+		t.Errorf("unexpected request: %s %s %v", gotUri, gotSerial, got)
+	}
+
+	sub := c.SubMerchant("2222222222", "wxyyyyyyyyyyyyyyyy")
+	if _, err := sub.ProfitSharingV3(ctx, V3ProfitSharingRequest{AppId: "wxxxxxxxxxxxxxxxxx", OutOrderNo: "P1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got["sub_mchid"] != "2222222222" || got["sub_appid"] != "wxyyyyyyyyyyyyyyyy" {
+		t.Errorf("unexpected request: %v", got)
+	}
+	if _, err := sub.UnfreezeProfitSharingV3(ctx, V3UnfreezeProfitSharingRequest{OutOrderNo: "P2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got["sub_mchid"] != "2222222222" {
+		t.Errorf("unexpected request: %v", got)
+	}
+	if _, err := sub.ReturnProfitSharingV3(ctx, V3ReturnProfitSharingRequest{OutOrderNo: "P1", OutReturnNo: "R1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got["sub_mchid"] != "2222222222" {
+		t.Errorf("unexpected request: %v", got)
+	}
+	if _, err := sub.QueryProfitSharingV3(ctx, V3QueryProfitSharingRequest{TransactionId: "4208450740201411110007820472", OutOrderNo: "P1"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/orders/P1?sub_mchid=2222222222&transaction_id=4208450740201411110007820472" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+	if _, err := sub.QueryProfitSharingReturnV3(ctx, V3QueryProfitSharingReturnRequest{OutOrderNo: "P1", OutReturnNo: "R1"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/profitsharing/return-orders/R1?out_order_no=P1&sub_mchid=2222222222" {
+		t.Error("unexpected request uri:", gotUri)
+	}
+	if _, err := sub.AddProfitSharingReceiverV3(ctx, V3AddProfitSharingReceiverRequest{AppId: "wxxxxxxxxxxxxxxxxx", Account: "o1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got["sub_mchid"] != "2222222222" || got["sub_appid"] != "wxyyyyyyyyyyyyyyyy" {
+		t.Errorf("unexpected request: %v", got)
+	}
+	if _, err := sub.RemoveProfitSharingReceiverV3(ctx, V3RemoveProfitSharingReceiverRequest{AppId: "wxxxxxxxxxxxxxxxxx", Account: "o1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got["sub_mchid"] != "2222222222" || got["sub_appid"] != "wxyyyyyyyyyyyyyyyy" {
+		t.Errorf("unexpected request: %v", got)
+	}
+}
+
+func setCertificateForTest(t *testing.T, c *Client) *rsa.PrivateKey {
+	key, cert := generateCertificateForTest(t)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	if err := c.SetCertificate(string(certPEM), string(keyPEM)); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptSensitiveFields(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	key := setCertificateForTest(t, c)
+	// encrypt with our own key, so DecryptSensitive can decrypt it
+	c.platformPublicKeyId = "PUB_KEY_ID_0000000000000000000000000000000000"
+	c.platformPublicKey = &key.PublicKey
+	req := V3TransferRequests{
+		Transfers: []V3TransferRequest{{OutDetailNo: "D1", UserName: "张三"}, {OutDetailNo: "D2"}},
+	}
+	object, serial, err := c.encryptSensitiveFields(context.Background(), req.toJson(c))
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	if serial != c.platformPublicKeyId {
+		t.Error("unexpected serial:", serial)
+	}
+	details := object.(transferRequestJson).TransferDetailList
+	if details[0].UserName == "张三" || details[1].UserName != "" {
+		t.Errorf("unexpected details: %+v", details)
+	}
+	name, err := c.DecryptSensitive(details[0].UserName)
+	if err != nil || name != "张三" {
+		t.Error("unexpected decrypted name:", name, err)
+	}
+	if req.Transfers[0].UserName != "张三" {
+		t.Error("original request should not be modified")
+	}
+
+	_, serial, err = c.encryptSensitiveFields(context.Background(), V3CloseOrderRequest{}.toJson(c))
+	if err != nil || serial != "" {
+		t.Error("expected nothing to be encrypted:", serial, err)
+	}
+}
+
+func TestDownloadReceiptV3(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	pdf := []byte("%PDF-1.4")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Accept") == "application/json" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		if r.URL.Query().Get("token") != "xxx" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write(pdf)
+	}))
+	defer server.Close()
+	h := sha256.Sum256(pdf)
+	receipt := V3TransferReceipt{
+		SignatureStatus: "FINISHED",
+		HashType:        "SHA256",
+		HashValue:       strings.ToUpper(hex.EncodeToString(h[:])),
+		DownloadUrl:     server.URL + "/v3/billdownload/file?token=xxx",
+	}
+	r, err := c.DownloadReceiptV3(context.Background(), receipt)
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	if b, _ := io.ReadAll(r); !bytes.Equal(b, pdf) {
+		t.Error("unexpected receipt:", string(b))
+	}
+	receipt.HashValue = "00"
+	if _, err := c.DownloadReceiptV3(context.Background(), receipt); err == nil {
+		t.Error("expected error for hash mismatch")
+	}
+	receipt.DownloadUrl = server.URL + "/v3/billdownload/file?token=yyy"
+	if r, err := c.DownloadReceiptV3(context.Background(), receipt); !errors.As(err, &JsonResponseError{}) {
+		t.Error("expected JsonResponseError for status 204, got:", r, err)
+	}
+}
+
+func TestEncryptWithBankPublicKey(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	key := setCertificateForTest(t, c)
+	if _, err := c.encryptWithBankPublicKey("6222000000000000"); err == nil {
+		t.Error("expected error when bank public key is not set")
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	if err := c.SetBankPublicKey(string(pubPEM)); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := c.encryptWithBankPublicKey("6222000000000000")
+	if err != nil {
+		t.Fatal("expected error to be nil:", err)
+	}
+	plaintext, err := c.DecryptSensitive(ciphertext)
+	if err != nil || plaintext != "6222000000000000" {
+		t.Error("unexpected decrypted bank number:", plaintext, err)
+	}
+}
+
+func TestCertificatesLazyInit(t *testing.T) {
+	c := &Client{MchId: "1111111111", Key: "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}
+	stores := make([]*certificateStore, 10)
+	var wg sync.WaitGroup
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stores[i] = c.certificates()
+		}(i)
+	}
+	wg.Wait()
+	for _, store := range stores {
+		if store != stores[0] {
+			t.Fatal("expected certificate store to be created once")
+		}
+	}
+	literal := &Client{MchId: "1111111111", Key: "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}
+	if literal.SubMerchant("2222222222", "").certificates() != literal.certificates() {
+		t.Error("expected sub-merchant client to share certificate store")
+	}
+}
+
+func TestRedPackQueryResponse(t *testing.T) {
+	var res RedPackQueryResponse
+	err := xml.Unmarshal([]byte(`<xml>
+<return_code><![CDATA[SUCCESS]]></return_code>
+<result_code><![CDATA[SUCCESS]]></result_code>
+<mch_billno><![CDATA[10000098201411111234567890]]></mch_billno>
+<status><![CDATA[RECEIVED]]></status>
+<hb_type><![CDATA[GROUP]]></hb_type>
+<total_num>2</total_num>
+<total_amount>300</total_amount>
+<send_time><![CDATA[2015-04-21 20:00:00]]></send_time>
+<hblist>
+<hbinfo><openid><![CDATA[o1]]></openid><amount>100</amount><rcv_time><![CDATA[2015-04-21 20:00:01]]></rcv_time></hbinfo>
+<hbinfo><openid><![CDATA[o2]]></openid><amount>200</amount><rcv_time><![CDATA[2015-04-21 20:00:02]]></rcv_time></hbinfo>
+</hblist>
+</xml>`), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.HbList) != 2 || res.HbList[1].OpenId != "o2" || res.HbList[1].Amount != 200 || res.HbList[1].RcvTime == nil {
+		t.Errorf("unexpected hb list: %+v", res.HbList)
+	}
+}
+
+func TestProfitSharingAmounts(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	req := ProfitSharingRequest{
+		AppId:         "wx0000000000000000",
+		TransactionId: "4200000000000000000000000000",
+		OutOrderNo:    "P1",
+		Receivers:     ProfitSharingAmounts{{Type: ProfitSharingReceiverMerchant, Account: "1900000109", Amount: 100, Description: "分给商户"}},
+	}
+	xmlObject := req.toXml(c).(profitSharingRequestXml)
+	if xmlObject.SignType != "HMAC-SHA256" {
+		t.Error("unexpected sign type:", xmlObject.SignType)
+	}
+	if xmlObject.Receivers != `[{"type":"MERCHANT_ID","account":"1900000109","amount":100,"description":"分给商户"}]` {
+		t.Error("unexpected receivers:", xmlObject.Receivers)
+	}
+
+	var res QueryProfitSharingResponse
+	err := xml.Unmarshal([]byte(`<xml>
+<return_code><![CDATA[SUCCESS]]></return_code>
+<result_code><![CDATA[SUCCESS]]></result_code>
+<status><![CDATA[FINISHED]]></status>
+<receivers><![CDATA[[{"type":"MERCHANT_ID","account":"1900000109","amount":100,"description":"分给商户","result":"SUCCESS","finish_time":"20180608170132"}]]]></receivers>
+</xml>`), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Receivers) != 1 || res.Receivers[0].Result != "SUCCESS" || res.Receivers[0].Amount != 100 {
+		t.Errorf("unexpected receivers: %+v", res.Receivers)
+	}
+}
+
+func TestSubMerchant(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	sub := c.SubMerchant("2222222222", "wx2222222222222222")
+	if c.SubMchId != "" || sub.MchId != c.MchId || sub.Key != c.Key {
+		t.Errorf("unexpected sub-merchant client: %+v", sub)
+	}
+	xmlObject := QueryOrderRequest{AppId: "wx1111111111111111", OutTradeNo: "T1"}.toXml(sub).(queryOrderRequestXml)
+	if xmlObject.MchId != "1111111111" || xmlObject.SubMchId != "2222222222" || xmlObject.SubAppId != "wx2222222222222222" {
+		t.Errorf("unexpected request: %+v", xmlObject)
+	}
+	if sub.transactionsUrlV3() != v3PartnerTransactionsUrl || c.transactionsUrlV3() != v3TransactionsUrl {
+		t.Error("unexpected transactions url")
+	}
+	req := V3CreateOrderRequest{AppId: "wx1111111111111111", Payer: &V3Payer{SubOpenId: "o2"}}
+	b, _ := json.Marshal(req.toJson(sub))
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	if m["sp_mchid"] != "1111111111" || m["sub_mchid"] != "2222222222" || m["sp_appid"] != "wx1111111111111111" {
+		t.Error("unexpected partner request:", string(b))
+	}
+	if payer, _ := m["payer"].(map[string]interface{}); payer["sub_openid"] != "o2" {
+		t.Error("unexpected payer:", string(b))
+	}
+	if sub.merchantQueryV3() != "sp_mchid=1111111111&sub_mchid=2222222222" {
+		t.Error("unexpected query:", sub.merchantQueryV3())
+	}
+}
+
+func TestCombineTransaction(t *testing.T) {
+	n := V3Notification{Plaintext: []byte(`{"combine_appid":"wxd678efh567hg6787","combine_mchid":"1900000109","combine_out_trade_no":"C1","sub_orders":[{"mchid":"1900000109","trade_state":"SUCCESS","transaction_id":"4200000000000000000000000001","out_trade_no":"S1","amount":{"total_amount":10,"payer_amount":10,"currency":"CNY","payer_currency":"CNY"}},{"mchid":"1900000110","trade_state":"NOTPAY","out_trade_no":"S2","amount":{"total_amount":20}}],"combine_payer_info":{"openid":"o1"}}`)}
+	transaction, err := n.CombineTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transaction.SubOrders) != 2 || !transaction.SubOrders[0].Paid() || transaction.SubOrders[1].Paid() {
+		t.Errorf("unexpected sub orders: %+v", transaction.SubOrders)
+	}
+	if transaction.SubOrders[1].Amount.TotalAmount != 20 || transaction.CombinePayerInfo.OpenId != "o1" {
+		t.Errorf("unexpected transaction: %+v", transaction)
+	}
+}
+
+func TestCombineOrderV3(t *testing.T) {
+	c := NewClient("1900000109", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	setCertificateForTest(t, c)
+	var got map[string]interface{}
+	var gotMethod, gotUri string
+	server, _ := v3ServerForTest(t, c, func(r *http.Request, body []byte) (int, string) {
+		got = nil
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Error(err)
+			}
+		}
+		gotMethod = r.Method
+		gotUri = r.URL.RequestURI()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/close"):
+			return 204, ""
+		case r.Method == http.MethodGet:
+			return 200, `{"combine_appid":"wxd678efh567hg6787","combine_mchid":"1900000109","combine_out_trade_no":"C1",` +
+				`"sub_orders":[{"mchid":"1900000109","trade_state":"SUCCESS","out_trade_no":"S1","amount":{"total_amount":10}},` +
+				`{"mchid":"1900000110","trade_state":"NOTPAY","out_trade_no":"S2","amount":{"total_amount":20}}],` +
+				`"combine_payer_info":{"openid":"o1"}}`
+		case strings.HasSuffix(r.URL.Path, "/native"):
+			return 200, `{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`
+		}
+		return 200, `{"prepay_id":"wx201410272009395522657a690389285100"}`
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	req := V3CreateCombineOrderRequest{
+		CombineAppId:      "wxd678efh567hg6787",
+		CombineOutTradeNo: "C1",
+		SubOrders: []V3CombineSubOrder{
+			{MchId: "1900000109", Attach: "a", Amount: V3CombineAmount{TotalAmount: 10}, OutTradeNo: "S1", Description: "s1"},
+			{MchId: "1900000110", Attach: "b", Amount: V3CombineAmount{TotalAmount: 20, Currency: "USD"}, OutTradeNo: "S2", Description: "s2"},
+		},
+		CombinePayerInfo: &V3Payer{OpenId: "o1"},
+		TimeExpire:       time.Date(2022, 3, 11, 11, 11, 23, 0, time.FixedZone("UTC+8", 8*60*60)),
+		NotifyUrl:        "https://example.com/notify",
+	}
+	res, err := c.CreateCombineJSAPIOrderV3(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "POST" || gotUri != "/v3/combine-transactions/jsapi" || res.PrepayId != "wx201410272009395522657a690389285100" {
+		t.Errorf("unexpected request or response: %s %s %+v", gotMethod, gotUri, res)
+	}
+	subOrders, _ := got["sub_orders"].([]interface{})
+	payer, _ := got["combine_payer_info"].(map[string]interface{})
+	if got["combine_mchid"] != "1900000109" || got["combine_appid"] != "wxd678efh567hg6787" ||
+		got["time_expire"] != "2022-03-11T11:11:23+08:00" || payer["openid"] != "o1" || len(subOrders) != 2 {
+		t.Fatalf("unexpected request: %v", got)
+	}
+	first, _ := subOrders[0].(map[string]interface{})
+	second, _ := subOrders[1].(map[string]interface{})
+	firstAmount, _ := first["amount"].(map[string]interface{})
+	secondAmount, _ := second["amount"].(map[string]interface{})
+	if firstAmount["currency"] != "CNY" || firstAmount["total_amount"] != 10.0 || secondAmount["currency"] != "USD" {
+		t.Errorf("unexpected sub orders: %v", subOrders)
+	}
+	if req.SubOrders[0].Amount.Currency != "" {
+		t.Error("original request should not be modified")
+	}
+
+	req.CombinePayerInfo = nil
+	res, err = c.CreateCombineNativeOrderV3(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUri != "/v3/combine-transactions/native" || res.CodeUrl != "weixin://wxpay/bizpayurl?pr=p4lpSuKzz" {
+		t.Errorf("unexpected request or response: %s %+v", gotUri, res)
+	}
+	if _, ok := got["combine_payer_info"]; ok {
+		t.Error("expected combine_payer_info to be omitted")
+	}
+
+	order, err := c.QueryCombineOrderV3(ctx, V3QueryCombineOrderRequest{CombineOutTradeNo: "C1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "GET" || gotUri != "/v3/combine-transactions/out-trade-no/C1" {
+		t.Errorf("unexpected request: %s %s", gotMethod, gotUri)
+	}
+	if len(order.SubOrders) != 2 || !order.SubOrders[0].Paid() || order.SubOrders[1].Amount.TotalAmount != 20 ||
+		order.CombinePayerInfo.OpenId != "o1" {
+		t.Errorf("unexpected response: %+v", order)
+	}
+
+	err = c.CloseCombineOrderV3(ctx, V3CloseCombineOrderRequest{
+		CombineAppId:      "wxd678efh567hg6787",
+		CombineOutTradeNo: "C1",
+		SubOrders: []V3CombineCloseSubOrder{
+			{MchId: "1900000109", OutTradeNo: "S1"},
+			{MchId: "1900000110", OutTradeNo: "S2"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subOrders, _ = got["sub_orders"].([]interface{})
+	if gotMethod != "POST" || gotUri != "/v3/combine-transactions/out-trade-no/C1/close" ||
+		got["combine_appid"] != "wxd678efh567hg6787" || len(subOrders) != 2 {
+		t.Errorf("unexpected request: %s %s %v", gotMethod, gotUri, got)
+	}
+}
+
+func TestHTTPClientReuse(t *testing.T) {
+	c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	first := c.httpClient()
+	if c.httpClient() != first {
+		t.Error("expected http client to be reused")
+	}
+	setCertificateForTest(t, c)
+	second := c.httpClient()
+	if second == first || second.Transport.(*http.Transport).TLSClientConfig != c.TLSClientConfig {
+		t.Error("expected http client to be rebuilt with new TLS config")
+	}
+	sub := c.SubMerchant("2222222222", "")
+	if sub.httpClient() != second {
+		t.Error("expected sub-merchant client to share http client")
+	}
+	// certificate rotated after the sub-merchant client is created
+	setCertificateForTest(t, c)
+	third := c.httpClient()
+	if third == second || third.Transport.(*http.Transport).TLSClientConfig != c.TLSClientConfig {
+		t.Error("expected http client to be built with rotated TLS config")
+	}
+	for i := 0; i < 3; i++ {
+		if sub.httpClient() != second || c.httpClient() != third {
+			t.Fatal("expected http clients of both TLS configs to be reused")
+		}
+	}
+	custom := &http.Client{}
+	c.HTTPClient = custom
+	if c.httpClient() != custom {
+		t.Error("expected custom http client to be used")
+	}
+
+	literal := &Client{MchId: "1111111111", Key: "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}
+	clients := make([]*http.Client, 10)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i] = literal.httpClient()
+		}(i)
+	}
+	wg.Wait()
+	for _, client := range clients {
+		if client != clients[0] {
+			t.Fatal("expected http client of literal client to be created once")
+		}
+	}
+	literal = &Client{MchId: "1111111111", Key: "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}
+	if literal.SubMerchant("2222222222", "").httpClient() != literal.httpClient() {
+		t.Error("expected sub-merchant copy of literal client to share http client")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// redirectForTest sends all requests of the client to the test server.
+func redirectForTest(c *Client, server *httptest.Server) {
+	serverUrl, _ := url.Parse(server.URL)
+	c.HTTPClient = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme = serverUrl.Scheme
+		r.URL.Host = serverUrl.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+}
+
+func signedXmlForTest(c *Client, values map[string]string, signType string) string {
+	values["sign"] = c.signString(joinStringToSign(values, c.Key), signType)
+	var body strings.Builder
+	body.WriteString("<xml>")
+	for name, value := range values {
+		body.WriteString("<" + name + "><![CDATA[" + value + "]]></" + name + ">")
+	}
+	body.WriteString("</xml>")
+	return body.String()
+}
+
+// micropayServerForTest replies USERPAYING to micropay, the trade states in
+// turn to order queries and the recalls in turn to reverses.
+func micropayServerForTest(t *testing.T, c *Client, states []string, recalls []string) (*httptest.Server, map[string][]map[string]string) {
+	var mu sync.Mutex
+	requests := map[string][]map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		req, err := parseXmlValues(b)
+		if err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		requests[r.URL.Path] = append(requests[r.URL.Path], req)
+		n := len(requests[r.URL.Path])
+		mu.Unlock()
+		res := map[string]string{
+			"return_code": "SUCCESS",
+			"result_code": "SUCCESS",
+			"appid":       req["appid"],
+			"mch_id":      req["mch_id"],
+			"nonce_str":   randomStr(32),
+		}
+		switch r.URL.Path {
+		case "/pay/micropay":
+			res["result_code"] = "FAIL"
+			res["err_code"] = "USERPAYING"
+		case "/pay/orderquery":
+			res["out_trade_no"] = req["out_trade_no"]
+			res["trade_state"] = states[n-1]
+		case "/secapi/pay/reverse":
+			res["recall"] = recalls[n-1]
+		}
+		w.Write([]byte(signedXmlForTest(c, res, req["sign_type"])))
+	}))
+	redirectForTest(c, server)
+	return server, requests
+}
+
+func TestMicropayAndWait(t *testing.T) {
+	req := MicropayRequest{
+		AppId:      "wxxxxxxxxxxxxxxxxx",
+		SignType:   "HMAC-SHA256",
+		Body:       "test",
+		OutTradeNo: "TESTz20220311z111122",
+		TotalFee:   100,
+		AuthCode:   "134567890123456789",
+	}
+	t.Run("paid", func(t *testing.T) {
+		t.Parallel()
+		c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+		server, requests := micropayServerForTest(t, c, []string{"SUCCESS"}, nil)
+		defer server.Close()
+		order, err := c.MicropayAndWait(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !order.Paid() || order.OutTradeNo != req.OutTradeNo {
+			t.Errorf("unexpected order: %+v", order)
+		}
+		queries := requests["/pay/orderquery"]
+		if len(queries) != 1 || queries[0]["sign_type"] != "HMAC-SHA256" {
+			t.Errorf("unexpected order queries: %v", queries)
+		}
+		if len(requests["/secapi/pay/reverse"]) != 0 {
+			t.Error("expected paid order not to be reversed")
+		}
+	})
+	t.Run("sub-merchant", func(t *testing.T) {
+		t.Parallel()
+		c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx").SubMerchant("2222222222", "wxyyyyyyyyyyyyyyyy")
+		server, requests := micropayServerForTest(t, c, []string{"SUCCESS"}, []string{"N"})
+		defer server.Close()
+		order, err := c.MicropayAndWait(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !order.Paid() {
+			t.Errorf("unexpected order: %+v", order)
+		}
+		if _, err := c.ReverseOrder(context.Background(), ReverseOrderRequest{AppId: req.AppId, OutTradeNo: req.OutTradeNo}); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"/pay/micropay", "/pay/orderquery", "/secapi/pay/reverse"} {
+			if len(requests[path]) != 1 || requests[path][0]["mch_id"] != "1111111111" ||
+				requests[path][0]["sub_mch_id"] != "2222222222" || requests[path][0]["sub_appid"] != "wxyyyyyyyyyyyyyyyy" {
+				t.Errorf("unexpected requests to %s: %v", path, requests[path])
+			}
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		c := NewClient("1111111111", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+		server, requests := micropayServerForTest(t, c, []string{"USERPAYING"}, []string{"Y", "N"})
+		defer server.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+		defer cancel()
+		order, err := c.MicropayAndWait(ctx, req)
+		if order != nil || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded, got: %+v, %v", order, err)
+		}
+		if len(requests["/pay/orderquery"]) != 1 {
+			t.Errorf("unexpected order queries: %v", requests["/pay/orderquery"])
+		}
+		reverses := requests["/secapi/pay/reverse"]
+		if len(reverses) != 2 || reverses[1]["out_trade_no"] != req.OutTradeNo {
+			t.Errorf("expected reverse to be retried on recall, got: %v", reverses)
+		}
+	})
+}